@@ -2,23 +2,31 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 
 	"github.com/takaxis2/rate-limiter/cmd/server/handler"
 	"github.com/takaxis2/rate-limiter/internals/broker"
 	"github.com/takaxis2/rate-limiter/internals/config"
+	"github.com/takaxis2/rate-limiter/internals/leader"
 	"github.com/takaxis2/rate-limiter/internals/limiters"
 	"github.com/takaxis2/rate-limiter/internals/logger"
 	metrics "github.com/takaxis2/rate-limiter/internals/metric"
-	worker "github.com/takaxis2/rate-limiter/internals/service"
+	"github.com/takaxis2/rate-limiter/internals/room"
 	"github.com/takaxis2/rate-limiter/internals/storage"
+	"github.com/takaxis2/rate-limiter/internals/ticket"
 )
 
 func main() {
@@ -45,18 +53,122 @@ func main() {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
-	qm := storage.NewQueueManager(rdb, "domain")
+	// 입장 이벤트. 레플리카끼리는 레디스 Pub/Sub으로 주고받는다
+	var eb broker.Broker = broker.NewEventBroker()
+	if strings.ToLower(cfg.Events.Store) == config.StoreRedis {
+		eb, err = broker.NewRedisBroker(ctx, rdb, cfg.Events.Channel)
+		if err != nil {
+			log.Fatalf("Failed to subscribe to event channel: %v", err)
+		}
+	}
+	defer eb.Stop()
+
+	// 메트릭 초기화. 대기실마다 domain 라벨로 기록한다
+	limiterMetrics := metrics.NewLimiterMetrics()
+	metrics := metrics.NewMetrics()
 
 	//서버 설정하기
 	//라우터 포함
-	//나중에 config 파일로 대체할 것
-	rl := limiters.NewTokenBucket(ctx, 3, 0.1, 1)
+	// 대기실마다 대기열, 입장 속도 리미터, 워커를 따로 만든다
+	// 리더 임대에 쓸 인스턴스 ID. 같은 호스트에서 여러 개 띄워도 겹치지 않게 한다
+	hostname, _ := os.Hostname()
+	instanceID := hostname + "-" + uuid.NewString()
+
+	rooms := room.NewRegistry()
+	for _, roomCfg := range cfg.Rooms {
+		rl, err := limiters.NewFromConfig(ctx, cfg.LimiterFor(roomCfg), rdb, cfg.RateLimit.KeyPrefix+":"+roomCfg.Name)
+		if err != nil {
+			log.Fatalf("Failed to create rate limiter for room %q: %v", roomCfg.Name, err)
+		}
 
-	eb := broker.NewEventBroker()
-	sm := handler.NewHandlers(rl, qm, eb)
+		// 보호하는 서비스의 응답을 보고 입장 속도를 조정한다
+		if cfg.RateLimit.Adaptive.Enabled {
+			target, ok := rl.(limiters.AdaptiveTarget)
+			if !ok {
+				log.Fatalf("Rate limiter %T for room %q cannot be adaptive", rl, roomCfg.Name)
+			}
+			rl, err = limiters.NewAdaptiveLimiter(target, limiters.AdaptiveConfigFrom(cfg.RateLimit.Adaptive), limiterMetrics.Adaptive(roomCfg.Name))
+			if err != nil {
+				log.Fatalf("Failed to create adaptive limiter for room %q: %v", roomCfg.Name, err)
+			}
+		}
 
-	// 메트릭 초기화 및 시작
-	metrics := metrics.NewMetrics(qm, "domain")
+		qm := storage.NewQueueManager(rdb, "queue:"+roomCfg.Name)
+		rm := room.New(roomCfg.Name, cfg.TargetFor(roomCfg), qm, rl, eb, metrics.Eviction(roomCfg.Name))
+		if err := rm.Worker.UpdateConfig(cfg.Queue.PollInterval, cfg.Queue.TickInterval, cfg.Queue.MaxBatch, cfg.Ticket.PassTTL); err != nil {
+			log.Fatalf("Failed to configure worker for room %q: %v", roomCfg.Name, err)
+		}
+		if err := rm.Positions.UpdateConfig(cfg.Queue.PositionInterval); err != nil {
+			log.Fatalf("Failed to configure position notifier for room %q: %v", roomCfg.Name, err)
+		}
+		if err := rm.Evictor.UpdateConfig(cfg.Queue.HeartbeatInterval, cfg.Queue.HeartbeatGrace, cfg.Queue.ReserveWindow); err != nil {
+			log.Fatalf("Failed to configure evictor for room %q: %v", roomCfg.Name, err)
+		}
+		if cfg.Leader.Enabled {
+			if !strings.EqualFold(cfg.LimiterFor(roomCfg).Store, config.StoreRedis) {
+				logger.Warn("Leader election with an in-memory limiter: a new leader starts with a full bucket and may admit a burst on failover; use rateLimit.store: redis",
+					zap.String("room", roomCfg.Name))
+			}
+			rm.ElectLeader(leader.NewElector(rdb, "leader:queue:"+roomCfg.Name, instanceID, cfg.Leader.TTL, metrics.Leadership(roomCfg.Name)))
+		}
+		if err := rooms.Add(rm, roomCfg.Hosts...); err != nil {
+			log.Fatalf("Failed to add room: %v", err)
+		}
+		metrics.AddQueue(roomCfg.Name, qm)
+	}
+
+	// 클라이언트(IP)별 리미터. 설정 값은 Load에서 검사했으므로 여기서 한 번 만들어보고 실패하면 종료
+	// 설정을 다시 읽으면 새로 생기는 키부터 바뀐 값으로 만들도록 포인터로 들고 있는다
+	perClient := cfg.RateLimit.PerClient
+	var perClientLimiter, lastBuiltPerClient atomic.Pointer[config.LimiterConfig]
+	perClientLimiter.Store(&perClient.LimiterConfig)
+	lastBuiltPerClient.Store(&perClient.LimiterConfig)
+	clientKeyPrefix := cfg.RateLimit.KeyPrefix + ":client:"
+	probe, err := limiters.NewFromConfig(ctx, perClient.LimiterConfig, rdb, clientKeyPrefix+"probe")
+	if err != nil {
+		log.Fatalf("Failed to create per-client limiter: %v", err)
+	}
+	probe.Stop()
+	kl := limiters.NewKeyedLimiter(ctx, func(key string) limiters.RateLimiter {
+		limiterCfg := perClientLimiter.Load()
+		rl, err := limiters.NewFromConfig(ctx, *limiterCfg, rdb, clientKeyPrefix+key)
+		if err == nil {
+			lastBuiltPerClient.Store(limiterCfg)
+			return rl
+		}
+
+		// 지금 설정으로 만들 수 없으면 마지막으로 만들어졌던 설정으로 만든다 (nil 리미터를 넘기지 않는다)
+		logger.Error("Failed to create per-client limiter, falling back to the previous config", zap.String("key", key), zap.Error(err))
+		rl, err = limiters.NewFromConfig(ctx, *lastBuiltPerClient.Load(), rdb, clientKeyPrefix+key)
+		if err != nil {
+			// 둘 다 안 되면 이 키의 요청은 막는다. 키가 정리되면 다음 요청 때 다시 만들어본다
+			logger.Error("Failed to create per-client limiter from the previous config, denying requests", zap.String("key", key), zap.Error(err))
+			return limiters.DenyAll{}
+		}
+		return rl
+	}, perClient.IdleTimeout, perClient.MaxKeys)
+	defer kl.Stop()
+
+	// 동시에 처리 중인 요청 수 제한. 자리가 없으면 대기열에서 기다린다
+	concurrency := cfg.RateLimit.Concurrency
+	cl := limiters.NewConcurrencyLimiter(concurrency.MaxInFlight, concurrency.MaxQueue, concurrency.QueueTimeout)
+	defer cl.Stop()
+
+	// 대기열 티켓 서명
+	tickets, err := ticket.NewSigner(cfg.Ticket)
+	if err != nil {
+		log.Fatalf("Failed to create ticket signer: %v", err)
+	}
+
+	// 클라이언트별 리미터 키. 로드밸런서를 거친 요청만 X-Forwarded-For를 본다
+	trustedProxies, err := perClient.TrustedProxyPrefixes()
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
+	sm := handler.NewHandlers(rooms, kl, cl, eb, tickets, storage.NewClaimLedger(rdb), storage.NewReportLedger(rdb), cfg.Queue.TierRank, handler.TrustedProxyKey(trustedProxies))
+
+	// 메트릭 수집 시작
 	go metrics.StartMetricsCollection(ctx)
 
 	server := &http.Server{
@@ -78,8 +190,133 @@ func main() {
 	log.Printf("Server started on %v", cfg.Server.Address)
 
 	//워커 등록
-	wkr := worker.NewQueueWorker(qm, "domain", rl, eb)
-	wkr.Start(ctx)
+	rooms.Start(ctx)
+
+	// 설정 파일이 바뀌면 재시작 없이 리미터와 워커 값을 바꾼다
+	// 알고리즘, 저장소, 서버/레디스 주소처럼 상태를 옮길 수 없는 값은 재시작해야 적용된다
+	current := cfg
+	config.Watch(func(next *config.Config, err error) {
+		if err != nil {
+			logger.Error("Config reload rejected", zap.Error(err))
+			return
+		}
+
+		if next.Server != current.Server || next.Redis != current.Redis || next.RateLimit.KeyPrefix != current.RateLimit.KeyPrefix {
+			logger.Warn("Server, redis and keyPrefix changes require a restart")
+		}
+		if next.Events != current.Events {
+			logger.Warn("Events config changes require a restart")
+		}
+		if next.Leader != current.Leader {
+			logger.Warn("Leader config changes require a restart")
+		}
+		if !slices.Equal(next.Queue.Tiers, current.Queue.Tiers) {
+			logger.Warn("Queue tier changes require a restart")
+		}
+		if next.RateLimit.Adaptive != current.RateLimit.Adaptive {
+			logger.Warn("Adaptive config changes require a restart")
+		}
+		if !sameRooms(current.Rooms, next.Rooms) || next.Admission != current.Admission {
+			logger.Warn("Adding, removing, re-hosting or re-targeting rooms requires a restart")
+		}
+		if next.RateLimit.PerClient.IdleTimeout != current.RateLimit.PerClient.IdleTimeout ||
+			next.RateLimit.PerClient.MaxKeys != current.RateLimit.PerClient.MaxKeys ||
+			!slices.Equal(next.RateLimit.PerClient.TrustedProxies, current.RateLimit.PerClient.TrustedProxies) {
+			logger.Warn("PerClient idleTimeout, maxKeys and trustedProxies changes require a restart")
+		}
+
+		// 대기실 리미터는 모두 적용할 수 있을 때만 적용한다. 일부 대기실만 새 설정으로 도는 일이 없게 한다
+		var roomErr error
+		for _, roomCfg := range next.Rooms {
+			rm, ok := rooms.Get(roomCfg.Name)
+			if !ok {
+				continue
+			}
+			if err := limiters.CanReconfigure(rm.Limiter, next.LimiterFor(roomCfg)); err != nil {
+				roomErr = fmt.Errorf("room %s: %w", rm.Name, err)
+				break
+			}
+		}
+		if roomErr != nil {
+			logger.Error("Rate limiter reload rejected", zap.Error(roomErr))
+		} else {
+			for _, roomCfg := range next.Rooms {
+				rm, ok := rooms.Get(roomCfg.Name)
+				if !ok {
+					continue
+				}
+				limiterCfg := next.LimiterFor(roomCfg)
+				if err := limiters.Reconfigure(rm.Limiter, limiterCfg); err != nil {
+					logger.Error("Rate limiter reload failed", zap.String("room", rm.Name), zap.Error(err))
+				} else {
+					logger.Info("Rate limiter reloaded", zap.String("room", rm.Name), zap.String("type", limiterCfg.Type))
+				}
+			}
+		}
+
+		// 클라이언트별 리미터도 모든 키를 먼저 확인하고 적용한다
+		perClientCfg := next.RateLimit.PerClient.LimiterConfig
+		var perClientErr error
+		// DenyAll은 만들지 못한 키의 자리만 차지하므로 건너뛴다. 정리되면 새 설정으로 다시 만든다
+		kl.Each(func(key string, rl limiters.RateLimiter) {
+			if _, ok := rl.(limiters.DenyAll); ok {
+				return
+			}
+			if err := limiters.CanReconfigure(rl, perClientCfg); err != nil && perClientErr == nil {
+				perClientErr = err
+			}
+		})
+		if perClientErr != nil {
+			logger.Error("Per-client limiter reload rejected", zap.Error(perClientErr))
+		} else {
+			perClientLimiter.Store(&perClientCfg)
+			kl.Each(func(key string, rl limiters.RateLimiter) {
+				if _, ok := rl.(limiters.DenyAll); ok {
+					return
+				}
+				if err := limiters.Reconfigure(rl, perClientCfg); err != nil && perClientErr == nil {
+					perClientErr = err
+				}
+			})
+			if perClientErr != nil {
+				logger.Error("Per-client limiter reload failed for some keys", zap.Error(perClientErr))
+			} else {
+				logger.Info("Per-client limiter reloaded", zap.String("type", perClientCfg.Type), zap.Int("keys", kl.Len()))
+			}
+		}
+
+		concurrency := next.RateLimit.Concurrency
+		if err := cl.UpdateConfig(concurrency.MaxInFlight, concurrency.MaxQueue, concurrency.QueueTimeout); err != nil {
+			logger.Error("Concurrency limiter reload rejected", zap.Error(err))
+		} else {
+			logger.Info("Concurrency limiter reloaded",
+				zap.Int("maxInFlight", concurrency.MaxInFlight),
+				zap.Int("maxQueue", concurrency.MaxQueue),
+				zap.Duration("queueTimeout", concurrency.QueueTimeout))
+		}
+
+		for _, rm := range rooms.All() {
+			if err := rm.Worker.UpdateConfig(next.Queue.PollInterval, next.Queue.TickInterval, next.Queue.MaxBatch, next.Ticket.PassTTL); err != nil {
+				logger.Error("Worker reload rejected", zap.String("room", rm.Name), zap.Error(err))
+			} else {
+				logger.Info("Worker reloaded", zap.String("room", rm.Name), zap.Duration("tickInterval", next.Queue.TickInterval), zap.Int("maxBatch", next.Queue.MaxBatch))
+			}
+			if err := rm.Positions.UpdateConfig(next.Queue.PositionInterval); err != nil {
+				logger.Error("Position notifier reload rejected", zap.String("room", rm.Name), zap.Error(err))
+			}
+			if err := rm.Evictor.UpdateConfig(next.Queue.HeartbeatInterval, next.Queue.HeartbeatGrace, next.Queue.ReserveWindow); err != nil {
+				logger.Error("Evictor reload rejected", zap.String("room", rm.Name), zap.Error(err))
+			}
+		}
+
+		if err := tickets.UpdateConfig(next.Ticket); err != nil {
+			logger.Error("Ticket keys reload rejected", zap.Error(err))
+		} else {
+			logger.Info("Ticket keys reloaded", zap.String("currentKey", next.Ticket.CurrentKey), zap.Int("keys", len(next.Ticket.Keys)))
+		}
+
+		current = next
+	})
 
 	//종료 신호 대기
 	<-shutdown
@@ -89,12 +326,26 @@ func main() {
 	shutdownCtx, cnacel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cnacel()
 
-	//워커 종료
-
 	//서버 종료
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	//워커, 리미터 종료
+	rooms.Stop()
+
 	log.Println("Server stopped gracefully")
 }
+
+// sameRooms 대기실 이름, 호스트, 입장 주소가 그대로인지. 리미터 설정은 다시 읽을 때 바로 적용된다
+func sameRooms(a, b []config.RoomConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].TargetURL != b[i].TargetURL || !slices.Equal(a[i].Hosts, b[i].Hosts) {
+			return false
+		}
+	}
+	return true
+}
@@ -4,18 +4,30 @@ package handler
 //그럼 레디스
 import (
 	// "encoding/json"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"github.com/takaxis2/rate-limiter/internals/broker"
 	"github.com/takaxis2/rate-limiter/internals/limiters"
-	"github.com/takaxis2/rate-limiter/internals/storage"
+	"github.com/takaxis2/rate-limiter/internals/room"
+	"github.com/takaxis2/rate-limiter/internals/ticket"
+	"github.com/takaxis2/rate-limiter/pkg/admission"
 	// "time"
 )
 
@@ -30,48 +42,99 @@ type RequestData struct {
 	RequestType string `json:"request_type"`
 }
 
-type QueueStatus struct {
-	Position      int           `json:"position"`       // 대기열에서의 위치
-	EstimatedWait time.Duration `json:"estimated_wait"` // 예상 대기 시간
-	QueueLength   int           `json:"queue_length"`   // 전체 대기열 길이
-}
-
 type TokenBucketConfig struct {
 	Capacity   float32 `json:"capacity"`
 	RefillRate float32 `json:"refillRate"`
 }
 
-type UserStatus string
+// OutcomeReport 보호하는 서비스가 알려주는 요청 처리 결과
+type OutcomeReport struct {
+	LatencyMs int64 `json:"latency_ms"`
+	Success   bool  `json:"success"`
+}
 
-const (
-	StatusQueued    UserStatus = "queued"
-	StatusProcessed UserStatus = "processed"
-)
+// ticketCookie 서명된 대기열 티켓을 담는 쿠키
+const ticketCookie = "QueueTicket"
 
-type UserInfo struct {
-	ID     string     `json:"id"`
-	Status UserStatus `json:"status"`
+// claimParam 보호하는 서비스가 발급한 등급 클레임(ticket.KindClaim)을 붙여 보내는 쿼리 파라미터
+const claimParam = admission.ClaimParam
+
+// ClaimSpender 등급 클레임을 한 번만 쓰게 한다 (storage.ClaimLedger)
+type ClaimSpender interface {
+	// Spend 처음 쓰는 클레임이면 true
+	Spend(ctx context.Context, nonce string, expires time.Time) (bool, error)
+	// Release Spend를 되돌린다. 클레임을 쓰고도 대기열에 넣지 못했을 때 다시 쓸 수 있게 한다
+	Release(ctx context.Context, nonce string) error
 }
 
-func NewHandlers(rl limiters.RateLimiter, qm *storage.QueueManager, eb *broker.EventBroker) *http.ServeMux {
+// TierRank 등급 이름을 대기열 순서로 바꾼다. 작을수록 먼저 입장한다 (config.QueueConfig.TierRank)
+type TierRank func(tier string) (rank int, ok bool)
+
+const (
+	eventHeartbeat = 15 * time.Second // 프록시가 유휴 연결을 끊지 않도록 보내는 간격 (SSE, 웹소켓)
+	sseRetry       = 3 * time.Second  // 끊겼을 때 브라우저가 다시 연결하기까지 기다리는 시간
+)
+
+// NewHandlers 대기실은 경로의 {room} 또는 Host 헤더로 고른다
+func NewHandlers(rooms *room.Registry, kl *limiters.KeyedLimiter, cl *limiters.ConcurrencyLimiter, eb broker.Broker, tickets *ticket.Signer, claims ClaimSpender, reports ReplayGuard, tierRank TierRank, clientKey ClientKey) *http.ServeMux {
 
 	sm := http.NewServeMux()
-	sm.HandleFunc("/api/request", RequestHandler(qm, rl)) // 핸들러 함수로 변경
-	sm.HandleFunc("/api/wait", WaitHandler(qm))           // 핸들러 함수로 변경
-	sm.HandleFunc("/api/events", EventsHandler(eb))       // 핸들러 함수로 변경
+	request := ConcurrencyLimit(cl, RequestHandler(rooms, kl, tickets, claims, tierRank, clientKey))
+	sm.HandleFunc("/api/request", request)                          // 핸들러 함수로 변경
+	sm.HandleFunc("/api/request/{room}", request)                   // 핸들러 함수로 변경
+	sm.HandleFunc("/api/wait", WaitHandler(rooms, tickets))         // 핸들러 함수로 변경
+	sm.HandleFunc("/api/events", EventsHandler(rooms, eb, tickets)) // 핸들러 함수로 변경
+	sm.HandleFunc("/api/ws", WebSocketHandler(rooms, eb, tickets))
+	sm.HandleFunc("/api/heartbeat", HeartbeatHandler(rooms, tickets))
+	sm.HandleFunc("/api/enter", EnterHandler(rooms, tickets))
+	sm.HandleFunc("/api/verify", VerifyHandler(tickets))
 	sm.HandleFunc("/api/position", func(w http.ResponseWriter, r *http.Request) {})
 	sm.Handle("/metric", promhttp.Handler())
-	sm.HandleFunc("/config/tb", TokenBucketConfigHandler(rl))
+	sm.HandleFunc("/config/tb", TokenBucketConfigHandler(rooms))
+	sm.HandleFunc("/config/tb/{room}", TokenBucketConfigHandler(rooms))
+	sm.HandleFunc("/api/report", ReportHandler(rooms, tickets, reports))
+	sm.HandleFunc("/api/report/{room}", ReportHandler(rooms, tickets, reports))
 
 	return sm
 }
 
-func RequestHandler(qm *storage.QueueManager, rl limiters.RateLimiter) http.HandlerFunc {
+// resolveRoom 요청의 대기실을 찾고, 없으면 404를 쓰고 false
+func resolveRoom(w http.ResponseWriter, r *http.Request, rooms *room.Registry) (*room.Room, bool) {
+	rm, ok := rooms.Resolve(r)
+	if !ok {
+		http.Error(w, "Unknown room", http.StatusNotFound)
+	}
+	return rm, ok
+}
+
+// RequestHandler ?claim=<등급 클레임>이 있으면 그 등급 대기자들 뒤, 아래 등급 대기자들 앞에 선다
+func RequestHandler(rooms *room.Registry, kl *limiters.KeyedLimiter, tickets *ticket.Signer, claims ClaimSpender, tierRank TierRank, clientKey ClientKey) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		rm, ok := resolveRoom(w, r, rooms)
+		if !ok {
+			return
+		}
+		qm, rl := rm.Queue, rm.Limiter
+
+		// 클라이언트별 제한. 한 클라이언트가 전체 토큰을 다 쓰지 못하게 한다
+		if ok, retryAfter := kl.AllowKeyRetry(clientKey(r), 1); !ok {
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			}
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		rank, claim, ok := claimedRank(w, r, rm, tickets, claims, tierRank)
+		if !ok {
+			return
+		}
+
 		//request에서 도메인값을 가져온다
 		queueLen, err := qm.GetTotalClients(ctx)
 		if err != nil {
+			releaseClaim(ctx, claims, claim)
 			http.Error(w, "Queue error", http.StatusInternalServerError)
 			return
 		}
@@ -100,36 +163,142 @@ func RequestHandler(qm *storage.QueueManager, rl limiters.RateLimiter) http.Hand
 		// }
 
 		clientID := uuid.New().String()
-		userInfo := UserInfo{
-			ID: clientID,
-		}
 		// 대기자가 없고 토큰이 있는 경우에만 즉시 리다이렉트
 		if queueLen == 0 && rl.Allow(1) {
-			fmt.Fprintf(w, "목표 레이지로 리다이렉트")
-			userInfo.Status = StatusProcessed
+			admit(w, r, rm, tickets, clientID)
 		} else
 		// 그 외의 경우에는 무조건 대기열에 추가
 		// 대기열이 있거나, 토큰이 없거나, 둘다 해당되거나
 		{
-			userInfo.Status = StatusQueued
-
-			userInfoJson, err := json.Marshal(userInfo)
+			// 클라이언트가 ID나 대기실을 바꾸지 못하도록 서명한 티켓을 준다
+			token, t, err := tickets.Issue(clientID, rm.Name)
 			if err != nil {
+				releaseClaim(ctx, claims, claim)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
 			}
 
-			qm.AddClient(ctx, clientID)
+			if err := qm.AddClient(ctx, clientID, rank); err != nil {
+				releaseClaim(ctx, claims, claim)
+				http.Error(w, "Queue error", http.StatusInternalServerError)
+				return
+			}
 			http.SetCookie(w, &http.Cookie{
-				Name:  "UserInfo",
-				Value: base64.StdEncoding.EncodeToString(userInfoJson),
-				// HttpOnly: true,
+				Name:     ticketCookie,
+				Value:    token,
+				Path:     "/",
+				Expires:  t.Expires(),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
 			})
 			http.Redirect(w, r, "/api/wait", http.StatusSeeOther)
 		}
 	}
 }
 
-func WaitHandler(qm *storage.QueueManager) http.HandlerFunc {
+// claimedRank 등급 클레임을 검증해서 대기열 순서를 돌려준다. 클레임이 없으면 일반 등급의 순서
+// 위조되거나 만료됐거나 다른 대기실용이거나 모르는 등급이거나 이미 쓴 클레임이면 403을 쓰고 false
+// 클레임은 등급까지 확인한 뒤에 쓴 것으로 기록한다. 그 뒤에 대기열에 넣지 못하면 releaseClaim으로 되돌린다
+func claimedRank(w http.ResponseWriter, r *http.Request, rm *room.Room, tickets *ticket.Signer, claims ClaimSpender, tierRank TierRank) (int, *ticket.Ticket, bool) {
+	var claim *ticket.Ticket
+	tier := ""
+	if token := r.URL.Query().Get(claimParam); token != "" {
+		t, err := tickets.Verify(token, ticket.KindClaim)
+		if err != nil || t.Nonce == "" || (t.Room != "" && t.Room != rm.Name) {
+			http.Error(w, "Invalid priority claim", http.StatusForbidden)
+			return 0, nil, false
+		}
+		claim, tier = t, t.Tier
+	}
+
+	rank, ok := tierRank(tier)
+	if !ok {
+		http.Error(w, "Unknown tier", http.StatusForbidden)
+		return 0, nil, false
+	}
+	if claim == nil {
+		return rank, nil, true
+	}
+
+	// 클레임이 담긴 주소가 새어도 한 사람만 우대받도록 한 번만 받는다
+	fresh, err := claims.Spend(r.Context(), claim.Nonce, claim.Expires())
+	if err != nil {
+		http.Error(w, "Queue error", http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	if !fresh {
+		http.Error(w, "Priority claim already used", http.StatusForbidden)
+		return 0, nil, false
+	}
+	return rank, claim, true
+}
+
+// releaseClaim 쓴 것으로 기록한 클레임을 되돌린다. claim이 nil이면 아무것도 하지 않는다
+func releaseClaim(ctx context.Context, claims ClaimSpender, claim *ticket.Ticket) {
+	if claim == nil {
+		return
+	}
+	if err := claims.Release(context.WithoutCancel(ctx), claim.Nonce); err != nil {
+		log.Printf("Error releasing priority claim %s: %v", claim.Nonce, err)
+	}
+}
+
+// ConcurrencyLimit 하위 핸들러가 끝날 때까지 동시 처리 자리를 잡는다
+// 자리가 나지 않으면 대기열 타임아웃 후 503을 돌려준다
+func ConcurrencyLimit(cl *limiters.ConcurrencyLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := cl.Acquire(r.Context(), 1); err != nil {
+			http.Error(w, "Server busy", http.StatusServiceUnavailable)
+			return
+		}
+		defer cl.Release(1)
+
+		next(w, r)
+	}
+}
+
+// ClientKey 클라이언트별 리미터에 쓸 키
+type ClientKey func(r *http.Request) string
+
+// TrustedProxyKey 기본은 연결한 주소(RemoteAddr)
+// 연결한 주소가 trusted 안에 있을 때만 X-Forwarded-For를 오른쪽부터 보고 처음 나오는 신뢰하지 않는 주소를 쓴다
+// 왼쪽 값은 클라이언트가 마음대로 넣을 수 있으므로 쓰지 않는다
+func TrustedProxyKey(trusted []netip.Prefix) ClientKey {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		peer, err := netip.ParseAddr(host)
+		if err != nil || !isTrusted(peer) {
+			return host
+		}
+
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// 형식이 틀린 값부터 왼쪽은 믿을 수 없다
+				break
+			}
+			if !isTrusted(addr) {
+				return addr.Unmap().String()
+			}
+		}
+		return host
+	}
+}
+
+func WaitHandler(rooms *room.Registry, tickets *ticket.Signer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		tmpl, err := template.ParseFiles("server/static/index.html")
@@ -138,33 +307,46 @@ func WaitHandler(qm *storage.QueueManager) http.HandlerFunc {
 			http.Error(w, "템플릿 로드 실패", http.StatusInternalServerError)
 			return
 		}
-		userInfoCookie, err := r.Cookie("UserInfo")
-		if err != nil {
-			http.Error(w, "유저 정보가 없습니다", http.StatusInternalServerError)
+		t, ok := queueTicket(w, r, tickets)
+		if !ok {
 			return
 		}
-		// base64 디코딩
-		userInfoBytes, err := base64.StdEncoding.DecodeString(userInfoCookie.Value)
-		if err != nil {
-			http.Error(w, "Invalid user info format", http.StatusInternalServerError)
+
+		rm, ok := rooms.Get(t.Room)
+		if !ok {
+			http.Error(w, "Unknown room", http.StatusNotFound)
 			return
 		}
 
-		// JSON 디코딩
-		var userInfo UserInfo
-		if err := json.Unmarshal(userInfoBytes, &userInfo); err != nil {
-			http.Error(w, "Invalid user info data", http.StatusInternalServerError)
+		// 탭을 닫았다가 금방 돌아왔으면 원래 자리로 되돌린다
+		if _, err := rm.Evictor.Keepalive(ctx, t.ID); err != nil {
+			http.Error(w, "Queue error", http.StatusInternalServerError)
 			return
 		}
 
-		wnum, err := qm.GetClientPosition(ctx, userInfo.ID)
+		wnum, err := rm.Queue.GetClientPosition(ctx, t.ID)
+		if err == redis.Nil {
+			// 대기열에 없으면 이미 입장했을 수 있다 (입장 이벤트를 놓치고 새로고침한 경우)
+			admitted, err := rm.Queue.IsAdmitted(ctx, t.ID)
+			if err != nil {
+				http.Error(w, "Queue error", http.StatusInternalServerError)
+				return
+			}
+			if admitted {
+				http.Redirect(w, r, "/api/enter", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Not in queue", http.StatusGone)
+			return
+		}
 		if err != nil {
 			http.Error(w, "유저 정보가 없습니다", http.StatusInternalServerError)
 			return
 		}
 
+		// ZRank는 0부터 센다. 위치 이벤트(QueueStatus.Position)와 같게 1부터 보여준다
 		data := map[string]interface{}{
-			"WaitingNumber": wnum,
+			"WaitingNumber": wnum + 1,
 		}
 
 		if err := tmpl.Execute(w, data); err != nil {
@@ -173,8 +355,119 @@ func WaitHandler(qm *storage.QueueManager) http.HandlerFunc {
 	}
 }
 
-func EventsHandler(eb *broker.EventBroker) http.HandlerFunc {
+// EnterHandler 워커가 입장시킨 사용자에게 통과권을 주고 보호하는 서비스로 보낸다
+// 대기 페이지가 입장 이벤트를 받으면 이리로 온다. 입장 표시는 한 번만 쓸 수 있다
+func EnterHandler(rooms *room.Registry, tickets *ticket.Signer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := queueTicket(w, r, tickets)
+		if !ok {
+			return
+		}
+
+		rm, ok := rooms.Get(t.Room)
+		if !ok {
+			http.Error(w, "Unknown room", http.StatusNotFound)
+			return
+		}
+
+		admitted, err := rm.Queue.ConsumeAdmitted(r.Context(), t.ID)
+		if err != nil {
+			http.Error(w, "Queue error", http.StatusInternalServerError)
+			return
+		}
+		if !admitted {
+			// 아직 차례가 아니면 대기 페이지로 돌려보낸다
+			http.Redirect(w, r, "/api/wait", http.StatusSeeOther)
+			return
+		}
+
+		// 다 쓴 대기열 티켓은 지운다
+		http.SetCookie(w, &http.Cookie{
+			Name:     ticketCookie,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+		admit(w, r, rm, tickets, t.ID)
+	}
+}
+
+// admit 통과권을 붙여서 대기실의 보호하는 서비스 주소로 보낸다
+func admit(w http.ResponseWriter, r *http.Request, rm *room.Room, tickets *ticket.Signer, clientID string) {
+	pass, _, err := tickets.IssuePass(clientID, rm.Name)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	target, err := admission.Append(rm.TargetURL, pass)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// VerifyHandler 보호하는 서비스가 통과권을 확인한다
+// Authorization: Bearer <통과권> 또는 ?pass=<통과권>. 유효하면 200, 아니면 401
+func VerifyHandler(tickets *ticket.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+
+		token := admission.BearerToken(r, admission.DefaultParam)
+		t, err := tickets.Verify(token, ticket.KindPass)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(admission.VerifyResponse{Error: err.Error()})
+			return
+		}
+
+		json.NewEncoder(w).Encode(admission.VerifyResponse{
+			Valid: true,
+			Pass: &admission.Pass{
+				ID:        t.ID,
+				Room:      t.Room,
+				ExpiresAt: t.Expires(),
+			},
+		})
+	}
+}
+
+// queueTicket 쿠키의 대기열 티켓을 검증한다. 없거나 위조, 만료된 경우 401을 쓰고 false
+func queueTicket(w http.ResponseWriter, r *http.Request, tickets *ticket.Signer) (*ticket.Ticket, bool) {
+	cookie, err := r.Cookie(ticketCookie)
+	if err != nil {
+		http.Error(w, "유저 정보가 없습니다", http.StatusUnauthorized)
+		return nil, false
+	}
+	t, err := tickets.Verify(cookie.Value, ticket.KindQueue)
+	if err != nil {
+		http.Error(w, "Invalid ticket", http.StatusUnauthorized)
+		return nil, false
+	}
+	return t, true
+}
+
+// EventsHandler 티켓 주인의 입장 이벤트와 대기실 전체 이벤트만 보낸다
+// 연결되어 있는 동안은 하트비트를 따로 보내지 않아도 대기열에서 빠지지 않는다
+func EventsHandler(rooms *room.Registry, eb broker.Broker, tickets *ticket.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := queueTicket(w, r, tickets)
+		if !ok {
+			return
+		}
+		rm, ok := rooms.Get(t.Room)
+		if !ok {
+			http.Error(w, "Unknown room", http.StatusNotFound)
+			return
+		}
+		keepalive(r.Context(), rm, t.ID)
+
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
@@ -186,28 +479,256 @@ func EventsHandler(eb *broker.EventBroker) http.HandlerFunc {
 			return
 		}
 
-		events := eb.Subsribe()
-		ctx := r.Context()
+		fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+		flusher.Flush()
 
-		for {
-			select {
-			case <-ctx.Done():
+		// 다시 연결한 경우 브라우저가 마지막으로 받은 id를 보낸다. 그 뒤에 놓친 이벤트부터 받는다
+		lastEventID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+		streamEvents(r.Context(), eb, rm, t, lastEventID, &sseSink{w: w, flusher: flusher})
+	}
+}
+
+// keepalive 연결하거나 ping을 보낼 때 하트비트를 갱신한다. 실패해도 연결은 유지하고 다음 틱의 일괄 갱신에 맡긴다
+func keepalive(ctx context.Context, rm *room.Room, clientID string) {
+	if _, err := rm.Evictor.Keepalive(ctx, clientID); err != nil {
+		log.Printf("Error refreshing heartbeat of %s in room %s: %v", clientID, rm.Name, err)
+	}
+}
+
+// HeartbeatHandler SSE나 웹소켓 없이 기다리는 클라이언트가 주기적으로 보낸다 (queue.heartbeatGrace보다 자주)
+// 대기 중이면 204, 대기열에 없으면 (자리 보관 시간이 지났거나 이미 입장했으면) 410
+func HeartbeatHandler(rooms *room.Registry, tickets *ticket.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		t, ok := queueTicket(w, r, tickets)
+		if !ok {
+			return
+		}
+		rm, ok := rooms.Get(t.Room)
+		if !ok {
+			http.Error(w, "Unknown room", http.StatusNotFound)
+			return
+		}
+
+		waiting, err := rm.Evictor.Keepalive(r.Context(), t.ID)
+		if err != nil {
+			http.Error(w, "Queue error", http.StatusInternalServerError)
+			return
+		}
+		if !waiting {
+			http.Error(w, "Not in queue", http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// eventSink SSE와 웹소켓이 같은 구독 루프(streamEvents)를 쓰도록 보내는 방법만 나눈다
+type eventSink interface {
+	send(e broker.Event) error
+	heartbeat() error
+}
+
+// streamEvents 티켓 주인의 이벤트와 대기실 이벤트를 보낸다
+// ctx가 끝나거나, 보내기에 실패하거나, 너무 느려서 브로커가 끊으면 돌아온다
+func streamEvents(ctx context.Context, eb broker.Broker, rm *room.Room, t *ticket.Ticket, lastEventID uint64, sink eventSink) {
+	sub := eb.Subscribe(t.ID, t.Room, lastEventID)
+	defer sub.Close()
+
+	// 재생 버퍼는 모든 대기실이 함께 쓰므로 끊긴 사이 입장 이벤트가 밀려났을 수 있다
+	// 구독한 뒤에 입장 표시를 확인해야 그 사이 입장해도 놓치지 않는다 (두 번 받으면 브라우저는 처음 것만 쓴다)
+	admitted, err := rm.Queue.IsAdmitted(ctx, t.ID)
+	if err != nil {
+		log.Printf("Error checking admission of %s in room %s: %v", t.ID, rm.Name, err)
+	} else if admitted {
+		if err := sink.send(broker.Event{Type: broker.EventAdmitted, Room: t.Room, UserID: t.ID}); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if err := sink.heartbeat(); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				// 너무 느려서 브로커가 끊었다. 클라이언트가 다시 연결한다
+				return
+			}
+			if err := sink.send(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type sseSink struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseSink) send(e broker.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// ID가 없는 이벤트(입장 표시를 보고 다시 보낸 입장 이벤트)는 브라우저의 Last-Event-ID를 바꾸지 않는다
+	if e.ID != 0 {
+		if _, err := fmt.Fprintf(s.w, "id: %d\n", e.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// heartbeat 주석 줄. 브라우저는 무시하고 프록시는 연결이 살아있다고 본다
+func (s *sseSink) heartbeat() error {
+	if _, err := fmt.Fprint(s.w, ": heartbeat\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// 웹소켓에서 클라이언트가 보내는 메시지 종류
+const (
+	wsPing  = "ping"  // 아직 대기 중이다
+	wsLeave = "leave" // 대기열에서 나간다
+)
+
+// 웹소켓에서만 서버가 보내는 메시지 종류. 나머지는 SSE와 같은 broker.Event
+const (
+	wsEventHeartbeat = "heartbeat"
+	wsEventPong      = "pong"
+	wsEventLeft      = "left"
+)
+
+const (
+	wsReadTimeout  = 60 * time.Second // 이 시간 동안 클라이언트 메시지가 없으면 끊는다. ping은 이보다 자주 보낸다
+	wsWriteTimeout = 10 * time.Second
+	wsMaxMessage   = 512
+)
+
+// wsUpgrader 티켓 쿠키로 인증하므로 다른 출처에서 연결하지 못하게 기본 Origin 검사를 쓴다
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsMessage 클라이언트가 보내는 메시지. {"type":"ping"} 또는 {"type":"leave"}
+type wsMessage struct {
+	Type string `json:"type"`
+}
+
+// WebSocketHandler SSE가 잘 안 되는 클라이언트용. EventsHandler와 같은 이벤트를 JSON 메시지로 보낸다
+// 다시 연결할 때는 ?lastEventId=<마지막으로 받은 id>를 붙인다
+func WebSocketHandler(rooms *room.Registry, eb broker.Broker, tickets *ticket.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := queueTicket(w, r, tickets)
+		if !ok {
+			return
+		}
+		rm, ok := rooms.Get(t.Room)
+		if !ok {
+			http.Error(w, "Unknown room", http.StatusNotFound)
+			return
+		}
+		keepalive(r.Context(), rm, t.ID)
+
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade가 에러 응답을 이미 썼다
+			return
+		}
+		defer conn.Close()
+
+		// 연결을 넘겨받은 뒤에는 클라이언트가 끊어도 r.Context()가 취소되지 않으므로 읽기 쪽에서 취소한다
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		sink := &wsSink{conn: conn}
+		go func() {
+			defer cancel()
+			readClient(ctx, conn, sink, rm, eb, t)
+		}()
+
+		lastEventID, _ := strconv.ParseUint(r.URL.Query().Get("lastEventId"), 10, 64)
+		streamEvents(ctx, eb, rm, t, lastEventID, sink)
+	}
+}
+
+// readClient 클라이언트 메시지를 처리한다. 연결이 끊기거나 나가면 돌아온다
+func readClient(ctx context.Context, conn *websocket.Conn, sink *wsSink, rm *room.Room, eb broker.Broker, t *ticket.Ticket) {
+	conn.SetReadLimit(wsMaxMessage)
+	for {
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case wsPing:
+			keepalive(ctx, rm, t.ID)
+			if err := sink.send(broker.Event{Type: wsEventPong}); err != nil {
+				return
+			}
+
+		case wsLeave:
+			removed, err := rm.Queue.RemoveClient(ctx, t.ID)
+			if err != nil {
+				log.Printf("Error removing client %s from room %s: %v", t.ID, rm.Name, err)
 				return
-			case userID := <-events:
-				event := map[string]string{
-					"type":    "processed",
-					"user_id": userID,
+			}
+			if removed {
+				// 뒤에 있는 사람들의 대기번호가 줄어든다
+				if err := eb.Publish(broker.Event{Type: broker.EventDequeued, Room: rm.Name}); err != nil {
+					log.Printf("Error publishing dequeue of %s in room %s: %v", t.ID, rm.Name, err)
 				}
-				data, _ := json.Marshal(event)
-				fmt.Fprintf(w, "data: %s\n\n", string(data))
-				// w.Write([]byte("data:" + string(data) + "\n\n"))
-				flusher.Flush()
 			}
+			sink.send(broker.Event{Type: wsEventLeft, Room: rm.Name})
+			return
 		}
 	}
 }
 
-func TokenBucketConfigHandler(rl limiters.RateLimiter) http.HandlerFunc {
+// wsSink 읽기 고루틴도 응답을 보내므로 쓰기를 잠근다 (gorilla/websocket은 동시 쓰기를 허용하지 않는다)
+type wsSink struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (s *wsSink) send(e broker.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return s.conn.WriteJSON(e)
+}
+
+func (s *wsSink) heartbeat() error {
+	return s.send(broker.Event{Type: wsEventHeartbeat})
+}
+
+// tokenBucketConfigurer limiters.TokenBucket, limiters.RedisTokenBucket 공통 설정 변경
+type tokenBucketConfigurer interface {
+	UpdateConfig(capacity, refillRate float32) error
+}
+
+func TokenBucketConfigHandler(rooms *room.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -231,15 +752,23 @@ func TokenBucketConfigHandler(rl limiters.RateLimiter) http.HandlerFunc {
 				return
 			}
 
-			// TokenBucket으로 타입 변환
-			tokenBucket, ok := rl.(*limiters.TokenBucket)
+			rm, ok := resolveRoom(w, r, rooms)
+			if !ok {
+				return
+			}
+
+			// 토큰 버킷(메모리, 레디스) 설정을 바꿀 수 있는지 확인
+			tokenBucket, ok := rm.Limiter.(tokenBucketConfigurer)
 			if !ok {
 				http.Error(w, "Rate limiter is not a token bucket", http.StatusInternalServerError)
 				return
 			}
 
 			// 설정 업데이트
-			tokenBucket.UpdateConfig(config.Capacity, config.RefillRate)
+			if err := tokenBucket.UpdateConfig(config.Capacity, config.RefillRate); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(map[string]string{
@@ -251,3 +780,92 @@ func TokenBucketConfigHandler(rl limiters.RateLimiter) http.HandlerFunc {
 		}
 	}
 }
+
+// outcomeReporter limiters.AdaptiveLimiter
+type outcomeReporter interface {
+	Report(latency time.Duration, success bool)
+}
+
+// ReplayGuard 같은 결과 보고를 한 번만 받게 한다 (storage.NewReportLedger)
+type ReplayGuard interface {
+	// Spend 처음 보는 서명이면 true
+	Spend(ctx context.Context, signature string, expires time.Time) (bool, error)
+}
+
+// 결과 보고 서명. 보호하는 서비스는 티켓 키로 "<타임스탬프>.<대기실>.<본문>"을 서명해서 보낸다 (admission.Issuer.SignReport)
+// 대기실 이름까지 서명하므로 한 대기실의 보고를 다른 대기실로 돌려 보낼 수 없다
+const (
+	reportMaxSkew = time.Minute // 이보다 오래됐거나 앞선 보고는 받지 않는다. 이 안에서의 재전송은 ReplayGuard가 막는다
+	reportMaxBody = 4 << 10
+)
+
+// ReportHandler 보호하는 서비스가 처리 결과를 보내면 적응형 리미터에 전달한다
+// 아무나 실패를 보내서 입장 속도를 떨어뜨리지 못하도록 서명을 확인하고, 가로챈 보고를 다시 보내지 못하도록 서명마다 한 번만 받는다
+func ReportHandler(rooms *room.Registry, tickets *ticket.Signer, reports ReplayGuard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rm, ok := resolveRoom(w, r, rooms)
+		if !ok {
+			return
+		}
+
+		body, ok := signedReport(w, r, rm, tickets, reports)
+		if !ok {
+			return
+		}
+
+		reporter, ok := rm.Limiter.(outcomeReporter)
+		if !ok {
+			http.Error(w, "Rate limiter is not adaptive", http.StatusNotImplemented)
+			return
+		}
+
+		var report OutcomeReport
+		if err := json.Unmarshal(body, &report); err != nil || report.LatencyMs < 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		reporter.Report(time.Duration(report.LatencyMs)*time.Millisecond, report.Success)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// signedReport 본문을 읽고 서명과 시각을 확인한다. 틀렸거나 이미 받은 보고면 401을 쓰고 false
+func signedReport(w http.ResponseWriter, r *http.Request, rm *room.Room, tickets *ticket.Signer, reports ReplayGuard) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, reportMaxBody))
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	timestamp := r.Header.Get(admission.ReportTimestampHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sec, 0)).Abs() > reportMaxSkew {
+		http.Error(w, "Invalid report timestamp", http.StatusUnauthorized)
+		return nil, false
+	}
+	msg := append([]byte(timestamp+"."+rm.Name+"."), body...)
+	signature := r.Header.Get(admission.ReportSignatureHeader)
+	if err := tickets.VerifyMessage(msg, signature); err != nil {
+		http.Error(w, "Invalid report signature", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// 시각 검사를 통과할 수 있는 동안만 기억하면 된다
+	fresh, err := reports.Spend(r.Context(), signature, time.Unix(sec, 0).Add(reportMaxSkew))
+	if err != nil {
+		log.Printf("Error recording report for room %s: %v", rm.Name, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !fresh {
+		http.Error(w, "Report already received", http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/takaxis2/rate-limiter/internals/config"
+	"github.com/takaxis2/rate-limiter/internals/room"
+	"github.com/takaxis2/rate-limiter/internals/ticket"
+	"github.com/takaxis2/rate-limiter/pkg/admission"
+)
+
+// memoryClaims 테스트용 ClaimSpender. 레디스 대신 맵에 기록한다
+type memoryClaims struct {
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+func (m *memoryClaims) Spend(_ context.Context, nonce string, _ time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.used[nonce] {
+		return false, nil
+	}
+	m.used[nonce] = true
+	return true, nil
+}
+
+func (m *memoryClaims) Release(_ context.Context, nonce string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.used, nonce)
+	return nil
+}
+
+// testTierRank vip만 아는 등급 순서
+func testTierRank(tier string) (int, bool) {
+	switch tier {
+	case "vip":
+		return 0, true
+	case "":
+		return 1, true
+	}
+	return 0, false
+}
+
+func newTestSigner(t *testing.T) *ticket.Signer {
+	t.Helper()
+	s, err := ticket.NewSigner(config.TicketConfig{
+		TTL:        time.Minute,
+		PassTTL:    time.Minute,
+		CurrentKey: "k1",
+		Keys:       []config.TicketKey{{ID: "k1", Secret: "test-secret-test-secret-test-secret"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestClaimedRankRejectsReuse(t *testing.T) {
+	tickets := newTestSigner(t)
+	claims := &memoryClaims{used: map[string]bool{}}
+	rm := &room.Room{Name: "default"}
+
+	token, _, err := tickets.IssueClaim("user-1", rm.Name, "vip", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "/api/request?" + url.Values{claimParam: {token}}.Encode()
+
+	w := httptest.NewRecorder()
+	rank, claim, ok := claimedRank(w, httptest.NewRequest(http.MethodGet, target, nil), rm, tickets, claims, testTierRank)
+	if !ok || rank != 0 || claim == nil {
+		t.Fatalf("first use: got rank %d ok %v (status %d), want vip rank 0", rank, ok, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	if _, _, ok := claimedRank(w, httptest.NewRequest(http.MethodGet, target, nil), rm, tickets, claims, testTierRank); ok {
+		t.Fatal("second use of the same claim was accepted")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("second use: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestClaimedRankRejectsClaimWithoutNonce(t *testing.T) {
+	tickets := newTestSigner(t)
+	claims := &memoryClaims{used: map[string]bool{}}
+	rm := &room.Room{Name: "default"}
+
+	// Nonce 없이 서명한 클레임은 기록할 수 없으므로 받지 않는다
+	token, err := tickets.Sign(&ticket.Ticket{
+		Kind:      ticket.KindClaim,
+		ID:        "user-1",
+		Tier:      "vip",
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "/api/request?" + url.Values{claimParam: {token}}.Encode()
+
+	w := httptest.NewRecorder()
+	if _, _, ok := claimedRank(w, httptest.NewRequest(http.MethodGet, target, nil), rm, tickets, claims, testTierRank); ok {
+		t.Fatal("claim without nonce was accepted")
+	}
+}
+
+func TestSignedReportRejectsReplayAndOtherRoom(t *testing.T) {
+	tickets := newTestSigner(t)
+	reports := &memoryClaims{used: map[string]bool{}}
+	rm := &room.Room{Name: "default"}
+
+	// 보호하는 서비스가 쓰는 admission.Issuer로 서명해서 형식이 맞는지도 확인한다
+	issuer, err := admission.NewIssuer("k1", "test-secret-test-secret-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"latency_ms":120,"success":false}`
+	signed := httptest.NewRequest(http.MethodPost, "/api/report", nil)
+	issuer.SignReport(signed, rm.Name, []byte(body))
+	send := func(rm *room.Room) (bool, int) {
+		r := httptest.NewRequest(http.MethodPost, "/api/report", strings.NewReader(body))
+		r.Header = signed.Header.Clone()
+		w := httptest.NewRecorder()
+		_, ok := signedReport(w, r, rm, tickets, reports)
+		return ok, w.Code
+	}
+
+	// 다른 대기실로 돌려 보낸 보고는 서명이 맞지 않는다
+	if ok, code := send(&room.Room{Name: "other"}); ok || code != http.StatusUnauthorized {
+		t.Fatalf("report for another room: got ok %v status %d, want rejected with %d", ok, code, http.StatusUnauthorized)
+	}
+	if ok, code := send(rm); !ok {
+		t.Fatalf("first report: rejected with status %d", code)
+	}
+	if ok, code := send(rm); ok || code != http.StatusUnauthorized {
+		t.Fatalf("replayed report: got ok %v status %d, want rejected with %d", ok, code, http.StatusUnauthorized)
+	}
+}
+
+func TestClaimedRankSpendsOnlyUsableClaims(t *testing.T) {
+	tickets := newTestSigner(t)
+	claims := &memoryClaims{used: map[string]bool{}}
+	rm := &room.Room{Name: "default"}
+
+	// 모르는 등급의 클레임은 거절하고 쓴 것으로 기록하지 않는다
+	token, unknown, err := tickets.IssueClaim("user-1", rm.Name, "gold", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if _, _, ok := claimedRank(w, httptest.NewRequest(http.MethodGet, "/api/request?"+url.Values{claimParam: {token}}.Encode(), nil), rm, tickets, claims, testTierRank); ok {
+		t.Fatal("claim with an unknown tier was accepted")
+	}
+	if claims.used[unknown.Nonce] {
+		t.Fatal("claim with an unknown tier was spent")
+	}
+
+	// 대기열에 넣지 못해 되돌린 클레임은 다시 쓸 수 있다
+	token, _, err = tickets.IssueClaim("user-1", rm.Name, "vip", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "/api/request?" + url.Values{claimParam: {token}}.Encode()
+	_, claim, ok := claimedRank(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil), rm, tickets, claims, testTierRank)
+	if !ok {
+		t.Fatal("valid claim rejected")
+	}
+	releaseClaim(context.Background(), claims, claim)
+	if _, _, ok := claimedRank(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil), rm, tickets, claims, testTierRank); !ok {
+		t.Fatal("released claim rejected")
+	}
+}
@@ -1,19 +1,225 @@
 package broker
 
+import "sync"
+
+// 이벤트 종류
+const (
+	EventAdmitted = "admitted" // 사용자 한 명이 입장했다. 본인에게만 보낸다
+	EventDequeued = "dequeued" // 대기실에서 사람이 빠졌다. Data는 빠진 인원 (없으면 1명). 대기실 전체에 보낸다
+	EventPosition = "position" // 현재 대기 순번과 예상 대기 시간. 본인에게만 보낸다
+)
+
+// subscriberBuffer 구독자마다 쌓아둘 수 있는 이벤트 수. 넘치면 느린 구독자로 보고 끊는다
+const subscriberBuffer = 16
+
+// replayBuffer 다시 연결한 구독자에게 보내줄 수 있도록 최근 이벤트를 남겨두는 수
+const replayBuffer = 1024
+
+// Event UserID가 있으면 그 사용자 구독에만, 없으면 Room 구독 전체에 보낸다
+// ID는 발행 순서대로 커진다. SSE의 id로 보내고 다시 연결할 때 Last-Event-ID로 받는다
+type Event struct {
+	ID     uint64 `json:"id,omitempty"`
+	Type   string `json:"type"`
+	Room   string `json:"room,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+	Data   any    `json:"data,omitempty"`
+}
+
+// Broker 이벤트를 구독자에게 전달한다
+// EventBroker는 한 인스턴스 안에서만, RedisBroker는 모든 인스턴스의 구독자에게 전달한다
+type Broker interface {
+	// Publish 실패하면 아무 구독자에게도 보내지 않는다
+	Publish(e Event) error
+	// Subscribe lastEventID보다 뒤의 이벤트 중 놓친 것을 먼저 받는다. 처음 연결이면 0
+	Subscribe(userID, room string, lastEventID uint64) *Subscription
+	// Notify 이 인스턴스의 구독자에게만 ID 없이 보낸다. 놓쳐도 다음에 다시 보내는 상태 갱신(대기 순번)에 쓴다
+	Notify(e Event)
+	// Users 이 인스턴스에서 room 이벤트를 구독 중인 사용자
+	Users(room string) []string
+	Stop()
+}
+
+// EventBroker 구독자마다 채널을 따로 두는 pub/sub
+// Publish는 막히지 않는다. 버퍼가 찬 구독자는 끊고, 구독자는 다시 연결해야 한다
 type EventBroker struct {
-	events chan string
+	mu     sync.Mutex
+	byUser map[string]map[*Subscription]struct{}
+	byRoom map[string]map[*Subscription]struct{}
+
+	lastID uint64
+	replay [replayBuffer]Event // 링 버퍼
+	head   int                 // 다음에 쓸 자리
+	size   int
 }
 
 func NewEventBroker() *EventBroker {
 	return &EventBroker{
-		events: make(chan string, 100),
+		byUser: make(map[string]map[*Subscription]struct{}),
+		byRoom: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Subscription Events 채널이 닫히면 브로커가 끊은 것이다 (느린 구독자)
+type Subscription struct {
+	b      *EventBroker
+	userID string
+	room   string
+	ch     chan Event
+	closed bool // b.mu로 보호
+}
+
+// Subscribe userID 본인 이벤트와 room 전체 이벤트를 받는다. 둘 중 하나는 비워도 된다
+func (b *EventBroker) Subscribe(userID, room string, lastEventID uint64) *Subscription {
+	s := &Subscription{
+		b:      b,
+		userID: userID,
+		room:   room,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 놓친 이벤트를 채널에 먼저 넣어두고 등록해야 순서가 섞이지 않는다
+	var missed []Event
+	if lastEventID > 0 {
+		b.eachReplay(func(e Event) {
+			if e.ID > lastEventID && s.wants(e) {
+				missed = append(missed, e)
+			}
+		})
+	}
+	s.ch = make(chan Event, subscriberBuffer+len(missed))
+	for _, e := range missed {
+		s.ch <- e
+	}
+
+	if userID != "" {
+		add(b.byUser, userID, s)
+	}
+	if room != "" {
+		add(b.byRoom, room, s)
+	}
+	return s
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close 연결이 끝나면 부른다. 여러 번 불러도 된다
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.remove(s)
+}
+
+// wants 사용자 이벤트는 본인에게, 대기실 이벤트는 그 대기실 구독자에게
+func (s *Subscription) wants(e Event) bool {
+	if e.UserID != "" {
+		return e.UserID == s.userID && (s.room == "" || e.Room == "" || e.Room == s.room)
+	}
+	return s.room != "" && e.Room == s.room
+}
+
+// Publish 대상 구독자에게 보낸다. ID가 없으면 붙인다 (RedisBroker는 레디스에서 받은 ID를 넣어서 부른다)
+func (b *EventBroker) Publish(e Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e.ID == 0 {
+		e.ID = b.lastID + 1
+	}
+	b.lastID = max(b.lastID, e.ID)
+	b.replay[b.head] = e
+	b.head = (b.head + 1) % replayBuffer
+	b.size = min(b.size+1, replayBuffer)
+
+	b.deliver(e)
+	return nil
+}
+
+// Notify 다시 보내줄 버퍼에 남기지 않는다
+func (b *EventBroker) Notify(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deliver(e)
+}
+
+// Users 같은 사용자가 여러 번 연결해도 한 번만 넣는다
+func (b *EventBroker) Users(room string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seen := make(map[string]struct{}, len(b.byRoom[room]))
+	users := make([]string, 0, len(b.byRoom[room]))
+	for s := range b.byRoom[room] {
+		if _, ok := seen[s.userID]; ok || s.userID == "" {
+			continue
+		}
+		seen[s.userID] = struct{}{}
+		users = append(users, s.userID)
 	}
+	return users
 }
 
-func (b *EventBroker) Subsribe() <-chan string {
-	return b.events
+// deliver b.mu를 잡은 상태에서 부른다
+func (b *EventBroker) deliver(e Event) {
+	subs := b.byRoom[e.Room]
+	if e.UserID != "" {
+		subs = b.byUser[e.UserID]
+	}
+	for s := range subs {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			// 버퍼가 찼다. 기다리지 않고 끊는다
+			b.remove(s)
+		}
+	}
 }
 
-func (b *EventBroker) Publish(userID string) {
-	b.events <- userID
+// eachReplay 남아있는 이벤트를 오래된 것부터. b.mu를 잡은 상태에서 부른다
+func (b *EventBroker) eachReplay(fn func(e Event)) {
+	start := (b.head - b.size + replayBuffer) % replayBuffer
+	for i := 0; i < b.size; i++ {
+		fn(b.replay[(start+i)%replayBuffer])
+	}
+}
+
+// Stop 메모리 브로커는 정리할 것이 없다
+func (b *EventBroker) Stop() {}
+
+// remove b.mu를 잡은 상태에서 부른다
+func (b *EventBroker) remove(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.userID != "" {
+		del(b.byUser, s.userID, s)
+	}
+	if s.room != "" {
+		del(b.byRoom, s.room, s)
+	}
+	close(s.ch)
+}
+
+func add(index map[string]map[*Subscription]struct{}, key string, s *Subscription) {
+	subs, ok := index[key]
+	if !ok {
+		subs = make(map[*Subscription]struct{})
+		index[key] = subs
+	}
+	subs[s] = struct{}{}
+}
+
+func del(index map[string]map[*Subscription]struct{}, key string, s *Subscription) {
+	subs := index[key]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(index, key)
+	}
 }
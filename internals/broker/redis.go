@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker 레디스 Pub/Sub으로 모든 인스턴스에 이벤트를 뿌린다
+// 워커가 입장시킨 인스턴스와 사용자의 SSE 연결이 붙은 인스턴스가 달라도 이벤트가 전달된다
+// 각 인스턴스는 채널에서 받은 이벤트를 자기 EventBroker로 로컬 구독자에게 전달한다
+// 이벤트 ID는 레디스 INCR로 붙여서 어느 인스턴스에 다시 연결해도 Last-Event-ID가 통한다
+type RedisBroker struct {
+	rdb      *redis.Client
+	channel  string
+	seqKey   string
+	local    *EventBroker
+	pubsub   *redis.PubSub
+	ctx      context.Context
+	stopFunc context.CancelFunc
+}
+
+func NewRedisBroker(ctx context.Context, rdb *redis.Client, channel string) (*RedisBroker, error) {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	pubsub := rdb.Subscribe(ctx, channel)
+	// 구독이 확인된 뒤에 반환해야 그 사이에 발행된 이벤트를 놓치지 않는다
+	if _, err := pubsub.Receive(ctx); err != nil {
+		cancelFunc()
+		pubsub.Close()
+		return nil, err
+	}
+
+	b := &RedisBroker{
+		rdb:      rdb,
+		channel:  channel,
+		seqKey:   channel + ":seq",
+		local:    NewEventBroker(),
+		pubsub:   pubsub,
+		ctx:      ctx,
+		stopFunc: cancelFunc,
+	}
+	go b.run()
+	return b, nil
+}
+
+// run 연결이 끊기면 go-redis가 다시 구독한다
+func (b *RedisBroker) run() {
+	for msg := range b.pubsub.Channel() {
+		var e Event
+		if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			log.Printf("redis broker %s: invalid event: %v", b.channel, err)
+			continue
+		}
+		b.local.Publish(e)
+	}
+}
+
+// Publish 레디스에 실패하면 에러를 돌려준다
+// 이 인스턴스에만 보내면 로컬 ID가 다른 인스턴스의 레디스 ID와 겹쳐서 Last-Event-ID가 어긋난다
+func (b *RedisBroker) Publish(e Event) error {
+	id, err := b.rdb.Incr(b.ctx, b.seqKey).Result()
+	if err != nil {
+		return fmt.Errorf("redis broker %s: %w", b.channel, err)
+	}
+	e.ID = uint64(id)
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("redis broker %s: %w", b.channel, err)
+	}
+	if err := b.rdb.Publish(b.ctx, b.channel, payload).Err(); err != nil {
+		return fmt.Errorf("redis broker %s: %w", b.channel, err)
+	}
+	return nil
+}
+
+func (b *RedisBroker) Subscribe(userID, room string, lastEventID uint64) *Subscription {
+	return b.local.Subscribe(userID, room, lastEventID)
+}
+
+// Notify 대기 순번처럼 인스턴스마다 자기 구독자 몫을 계산하는 이벤트는 레디스를 거치지 않는다
+func (b *RedisBroker) Notify(e Event) {
+	b.local.Notify(e)
+}
+
+func (b *RedisBroker) Users(room string) []string {
+	return b.local.Users(room)
+}
+
+func (b *RedisBroker) Stop() {
+	b.stopFunc()
+	b.pubsub.Close()
+}
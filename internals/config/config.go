@@ -1,8 +1,18 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
@@ -10,6 +20,13 @@ type Config struct {
 	Server    ServerConfig
 	Redis     RedisConfig
 	RateLimit RateLimitConfig
+	Queue     QueueConfig
+	Rooms     []RoomConfig
+	Ticket    TicketConfig
+	Admission AdmissionConfig
+	Events    EventsConfig
+	Leader    LeaderConfig
+	Env       string
 }
 
 type ServerConfig struct {
@@ -24,11 +41,258 @@ type RedisConfig struct {
 	DB       int
 }
 
+type QueueConfig struct {
+	PollInterval     time.Duration // 대기열이 비어있을 때 다시 확인하는 간격
+	TickInterval     time.Duration // 대기자가 있을 때 토큰을 확인해서 입장시키는 간격
+	MaxBatch         int           // 한 틱에 입장시키는 최대 인원
+	PositionInterval time.Duration // 연결된 대기자에게 순번과 예상 대기 시간을 보내는 간격
+
+	HeartbeatInterval time.Duration // 연결된 대기자의 하트비트를 갱신하고 끊긴 대기자를 빼는 간격
+	HeartbeatGrace    time.Duration // 이 시간 동안 하트비트가 없으면 대기열에서 뺀다
+	ReserveWindow     time.Duration // 빠진 뒤 이 시간 안에 돌아오면 원래 자리로 되돌린다. 0이면 보관하지 않는다
+
+	// Tiers 우선순위 등급. 앞에 있을수록 먼저 입장하고 같은 등급 안에서는 들어온 순서대로 입장한다
+	// 등급 클레임이 없는 사용자(DefaultTier)는 모든 등급 뒤에 선다
+	Tiers []string
+}
+
+// DefaultTier 등급 클레임 없이 들어온 사용자
+const DefaultTier = "general"
+
+// maxTiers 대기열 score에 등급을 곱해 넣으므로 float64로 정확히 표현되는 범위 안에서만 쓴다
+const maxTiers = 100
+
+// TierRank 등급의 순서. 작을수록 먼저 입장한다. 빈 문자열은 DefaultTier
+func (q QueueConfig) TierRank(tier string) (int, bool) {
+	if tier == "" || tier == DefaultTier {
+		return len(q.Tiers), true
+	}
+	for i, t := range q.Tiers {
+		if t == tier {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// LeaderConfig 레플리카 중 한 곳의 워커만 대기열을 비우도록 대기실마다 리더를 뽑는다
+type LeaderConfig struct {
+	Enabled bool
+	TTL     time.Duration // 리더 임대 시간. 리더가 죽으면 이 시간 안에 다른 레플리카가 이어받는다
+}
+
+// minLeaderTTL 임대는 TTL/3마다 갱신하므로 너무 짧으면 레디스 지연만으로 리더가 바뀐다
+const minLeaderTTL = time.Second
+
+// TicketConfig 대기열 티켓 서명. 키를 교체할 때는 새 키를 추가하고 currentKey를 바꾼 뒤
+// 기존 티켓이 모두 만료되면(ttl) 이전 키를 지운다
+type TicketConfig struct {
+	TTL        time.Duration // 대기열 티켓 유효 시간
+	PassTTL    time.Duration // 입장 통과권 유효 시간. 입장 처리 후 이 시간 안에 /api/enter로 받아가야 한다
+	CurrentKey string        // 새 티켓에 서명할 키 ID
+	Keys       []TicketKey
+}
+
+// TicketKey 비밀 값은 설정 파일에 적지 말고 secretEnv로 환경 변수 이름을 준다
+// 둘 다 비어있으면 dev에서는 프로세스마다 임의 값을 쓰고(레플리카끼리, 재시작 후에는 티켓이 통하지 않는다) prod에서는 띄우지 않는다
+type TicketKey struct {
+	ID        string
+	Secret    string
+	SecretEnv string // 비밀 값을 읽을 환경 변수. 있으면 secret보다 먼저 쓴다
+}
+
+// AdmissionConfig 입장한 사용자를 보낼 곳
+type AdmissionConfig struct {
+	TargetURL string // 보호하는 서비스 주소. 대기실마다 targetURL로 바꿀 수 있다
+}
+
+// EventsConfig 입장 이벤트 전달. 레플리카가 여러 개면 redis를 써야
+// 워커가 돈 인스턴스와 SSE 연결이 붙은 인스턴스가 달라도 이벤트가 간다
+type EventsConfig struct {
+	Store   string // memory(기본) 또는 redis
+	Channel string // 레디스 Pub/Sub 채널
+}
+
+// minTicketSecret HMAC-SHA256 키 최소 길이 (바이트)
+const minTicketSecret = 32
+
+// placeholderTicketSecret 예제 설정에 들어있던 값. 길이는 충분하지만 공개된 값이라 받지 않는다
+const placeholderTicketSecret = "change-me-to-a-random-secret-of-32-bytes-or-more"
+
+// EnvProd 운영 환경. 빠진 비밀 값을 임의 값으로 채우지 않는다
+const EnvProd = "prod"
+
+// IsProd env가 prod인지
+func (c *Config) IsProd() bool {
+	return strings.EqualFold(c.Env, EnvProd)
+}
+
+// devSecrets dev에서 비밀 값이 없는 키에 쓰는 임의 값. 설정을 다시 읽어도 같은 값을 쓰도록 키 ID별로 기억한다
+var devSecrets sync.Map
+
+// resolveSecrets secretEnv에서 비밀 값을 읽는다. prod가 아니고 비어있으면 임의 값을 쓴다
+func (c *TicketConfig) resolveSecrets(prod bool) error {
+	for i := range c.Keys {
+		key := &c.Keys[i]
+		if key.SecretEnv != "" {
+			if secret := os.Getenv(key.SecretEnv); secret != "" {
+				key.Secret = secret
+			}
+		}
+		if key.Secret != "" || prod {
+			continue
+		}
+
+		secret, ok := devSecrets.Load(key.ID)
+		if !ok {
+			buf := make([]byte, minTicketSecret)
+			if _, err := rand.Read(buf); err != nil {
+				return fmt.Errorf("ticket.keys[%d]: generating dev secret: %w", i, err)
+			}
+			secret, ok = devSecrets.LoadOrStore(key.ID, hex.EncodeToString(buf))
+			if !ok {
+				log.Printf("ticket.keys[%d] (%s) has no secret; using a random one for this process (env is not %q)", i, key.ID, EnvProd)
+			}
+		}
+		key.Secret = secret.(string)
+	}
+	return nil
+}
+
+// DefaultRoom rooms를 적지 않았을 때 만드는 대기실 이름
+const DefaultRoom = "domain"
+
+// RoomConfig 이벤트 하나의 대기실. 대기열, 리미터, 워커를 따로 가진다
+type RoomConfig struct {
+	Name      string
+	Hosts     []string       // 이 Host 헤더로 들어온 요청은 이 대기실로 보낸다
+	Limiter   *LimiterConfig // 없으면 rateLimit의 리미터 설정을 쓴다
+	TargetURL string         // 없으면 admission.targetURL
+}
+
+// LimiterFor 대기실에서 쓸 입장 속도 리미터 설정
+func (c *Config) LimiterFor(room RoomConfig) LimiterConfig {
+	if room.Limiter != nil {
+		return *room.Limiter
+	}
+	return c.RateLimit.LimiterConfig
+}
+
+// TargetFor 대기실에서 입장한 사용자를 보낼 주소
+func (c *Config) TargetFor(room RoomConfig) string {
+	if room.TargetURL != "" {
+		return room.TargetURL
+	}
+	return c.Admission.TargetURL
+}
+
+// 리미터 알고리즘
+const (
+	TypeTokenBucket          = "tokenbucket"
+	TypeLeakyBucket          = "leakybucket"
+	TypeFixedWindow          = "fixedwindow"
+	TypeSlidingWindow        = "slidingwindow"
+	TypeSlidingWindowCounter = "slidingwindowcounter"
+	TypeGCRA                 = "gcra"
+	TypeComposite            = "composite"
+)
+
+// 리미터 상태 저장 위치
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
 type RateLimitConfig struct {
-	KeyPrefix       string
+	KeyPrefix     string
+	LimiterConfig `mapstructure:",squash"`
+	Adaptive      AdaptiveConfig
+	PerClient     PerClientConfig
+	Concurrency   ConcurrencyConfig
+}
+
+// LimiterConfig 리미터 하나의 설정. Type에 해당하는 블록만 사용한다
+type LimiterConfig struct {
+	Type                 string
+	Store                string // memory(기본) 또는 redis. redis는 tokenbucket만 지원
+	TokenBucket          TokenBucketConfig
+	LeakyBucket          LeakyBucketConfig
+	FixedWindow          FixedWindowConfig
+	SlidingWindow        SlidingWindowConfig
+	SlidingWindowCounter SlidingWindowConfig
+	GCRA                 GCRAConfig
+	Limits               []LimiterConfig // composite일 때 모두 통과해야 하는 리미터들
+}
+
+type TokenBucketConfig struct {
 	Capacity        float64
-	TokensPerSecond float64
-	Tokens          float64
+	TokensPerSecond float64 // 초당 충전되는 토큰
+	Tokens          float64 // 처음 토큰 수
+}
+
+type LeakyBucketConfig struct {
+	Capacity int
+	LeakRate int // 초당 빠져나가는 양
+}
+
+type FixedWindowConfig struct {
+	WindowSize int // 초
+	Capacity   int
+}
+
+type SlidingWindowConfig struct {
+	Limit      int
+	WindowSize time.Duration
+}
+
+type GCRAConfig struct {
+	Rate  float64 // 초당 요청 수
+	Burst int
+}
+
+// AdaptiveConfig 토큰 버킷 속도를 보호하는 서비스 응답에 맞춰 조정 (tokenbucket만)
+type AdaptiveConfig struct {
+	Enabled        bool
+	MinRate        float64
+	MaxRate        float64
+	IncreaseStep   float64
+	DecreaseFactor float64
+	LatencyTarget  time.Duration
+	Interval       time.Duration
+}
+
+// PerClientConfig 클라이언트(IP)별 리미터. 키마다 같은 설정으로 만든다
+type PerClientConfig struct {
+	LimiterConfig `mapstructure:",squash"`
+	IdleTimeout   time.Duration
+	MaxKeys       int
+	// TrustedProxies 로드밸런서 주소(IP 또는 CIDR). 여기서 온 요청만 X-Forwarded-For를 본다
+	// 비어있으면 X-Forwarded-For를 무시하고 연결한 주소로 센다 (클라이언트가 헤더를 마음대로 바꿀 수 있다)
+	TrustedProxies []string
+}
+
+// TrustedProxyPrefixes TrustedProxies를 파싱한다. IP 하나는 /32 (/128)로 본다
+func (c PerClientConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for i, proxy := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("rateLimit.perClient.trustedProxies[%d]: %q is not an IP or CIDR", i, proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ConcurrencyConfig 동시 처리 요청 수 제한
+type ConcurrencyConfig struct {
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
 }
 
 func Load() (*Config, error) {
@@ -49,24 +313,319 @@ func Load() (*Config, error) {
 	viper.SetDefault("redis.db", 0)
 
 	viper.SetDefault("rateLimit.keyPrefix", "rateLimit")
-	viper.SetDefault("rateLimit.tokensPerSecond", 0.1)
-	viper.SetDefault("rateLimit.capacity", 10.0)
-	viper.SetDefault("rateLimit.tokens", 1.0)
+	viper.SetDefault("rateLimit.type", TypeTokenBucket)
+	viper.SetDefault("rateLimit.store", StoreMemory)
+	viper.SetDefault("rateLimit.tokenBucket.tokensPerSecond", 0.1)
+	viper.SetDefault("rateLimit.tokenBucket.capacity", 10.0)
+	viper.SetDefault("rateLimit.tokenBucket.tokens", 1.0)
+
+	viper.SetDefault("rateLimit.perClient.type", TypeGCRA)
+	viper.SetDefault("rateLimit.perClient.gcra.rate", 1.0)
+	viper.SetDefault("rateLimit.perClient.gcra.burst", 5)
+	viper.SetDefault("rateLimit.perClient.idleTimeout", "10m")
+	viper.SetDefault("rateLimit.perClient.maxKeys", 100000)
+
+	viper.SetDefault("rateLimit.concurrency.maxInFlight", 100)
+	viper.SetDefault("rateLimit.concurrency.maxQueue", 1000)
+	viper.SetDefault("rateLimit.concurrency.queueTimeout", "5s")
+
+	viper.SetDefault("queue.pollInterval", "1s")
+	viper.SetDefault("queue.tickInterval", "100ms")
+	viper.SetDefault("queue.maxBatch", 500)
+	viper.SetDefault("queue.positionInterval", "5s")
+	viper.SetDefault("queue.heartbeatInterval", "10s")
+	viper.SetDefault("queue.heartbeatGrace", "30s")
+	viper.SetDefault("queue.reserveWindow", "2m")
+
+	viper.SetDefault("ticket.ttl", "2h")
+	viper.SetDefault("ticket.passTTL", "5m")
+
+	viper.SetDefault("events.store", StoreMemory)
+	viper.SetDefault("events.channel", "queue:events")
+	viper.SetDefault("leader.enabled", true)
+	viper.SetDefault("leader.ttl", "5s")
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
 	}
 
+	return unmarshal()
+}
+
+// Watch 설정 파일이 바뀔 때마다 다시 읽어서 onChange를 부른다
+// 읽기나 검사에 실패하면 err와 함께 부르고, 이때 cfg는 nil이다
+func Watch(onChange func(cfg *Config, err error)) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		onChange(unmarshal())
+	})
+	viper.WatchConfig()
+}
+
+func unmarshal() (*Config, error) {
+	// 오타 난 키도 알 수 있도록 구조체에 없는 키가 있으면 실패
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	if err := viper.UnmarshalExact(&config); err != nil {
 		return nil, err
 	}
+	if len(config.Rooms) == 0 {
+		config.Rooms = []RoomConfig{{Name: DefaultRoom}}
+	}
+	if err := config.Ticket.resolveSecrets(config.IsProd()); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
 	return &config, nil
+}
 
+// Validate 서버를 띄우거나 설정을 다시 읽을 때 값 범위를 검사한다
+func (c *Config) Validate() error {
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+	if c.Queue.PollInterval <= 0 || c.Queue.TickInterval <= 0 || c.Queue.PositionInterval <= 0 {
+		return fmt.Errorf("queue.pollInterval, queue.tickInterval and queue.positionInterval must be greater than 0")
+	}
+	if c.Queue.MaxBatch <= 0 {
+		return fmt.Errorf("queue.maxBatch must be greater than 0")
+	}
+	if c.Queue.HeartbeatInterval <= 0 || c.Queue.HeartbeatGrace <= c.Queue.HeartbeatInterval {
+		return fmt.Errorf("queue: need 0 < heartbeatInterval < heartbeatGrace, got %v / %v", c.Queue.HeartbeatInterval, c.Queue.HeartbeatGrace)
+	}
+	if c.Queue.ReserveWindow < 0 {
+		return fmt.Errorf("queue.reserveWindow must not be negative")
+	}
+	if len(c.Queue.Tiers) > maxTiers {
+		return fmt.Errorf("queue.tiers: at most %d tiers, got %d", maxTiers, len(c.Queue.Tiers))
+	}
+	tiers := make(map[string]bool, len(c.Queue.Tiers))
+	for i, tier := range c.Queue.Tiers {
+		if !validRoomName(tier) || tier == DefaultTier {
+			return fmt.Errorf("queue.tiers[%d]: %q must use only letters, digits, '-' and '_' and not be %q", i, tier, DefaultTier)
+		}
+		if tiers[tier] {
+			return fmt.Errorf("queue.tiers[%d]: duplicate tier %q", i, tier)
+		}
+		tiers[tier] = true
+	}
+
+	if err := c.Ticket.validate(c.IsProd()); err != nil {
+		return err
+	}
+
+	switch strings.ToLower(c.Events.Store) {
+	case StoreMemory:
+	case StoreRedis:
+		if c.Events.Channel == "" {
+			return fmt.Errorf("events.channel is required for redis store")
+		}
+	default:
+		return fmt.Errorf("events.store: unknown store %q (memory, redis)", c.Events.Store)
+	}
+
+	if c.Leader.Enabled && c.Leader.TTL < minLeaderTTL {
+		return fmt.Errorf("leader.ttl must be at least %v, got %v", minLeaderTTL, c.Leader.TTL)
+	}
+
+	names := make(map[string]bool, len(c.Rooms))
+	hosts := make(map[string]string)
+	for i, room := range c.Rooms {
+		path := fmt.Sprintf("rooms[%d]", i)
+		if !validRoomName(room.Name) {
+			return fmt.Errorf("%s.name: %q must be non-empty and use only letters, digits, '-' and '_'", path, room.Name)
+		}
+		if names[room.Name] {
+			return fmt.Errorf("%s.name: duplicate room %q", path, room.Name)
+		}
+		names[room.Name] = true
+
+		for _, host := range room.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := hosts[host]; ok {
+				return fmt.Errorf("%s.hosts: %q is already used by room %q", path, host, other)
+			}
+			hosts[host] = room.Name
+		}
+
+		if room.Limiter != nil {
+			if err := room.Limiter.validate(path + ".limiter"); err != nil {
+				return err
+			}
+		}
+		if err := validTargetURL(c.TargetFor(room)); err != nil {
+			return fmt.Errorf("%s.targetURL (or admission.targetURL): %w", path, err)
+		}
+		if c.RateLimit.Adaptive.Enabled && strings.ToLower(c.LimiterFor(room).Type) != TypeTokenBucket {
+			return fmt.Errorf("%s: rateLimit.adaptive is only supported for %q", path, TypeTokenBucket)
+		}
+	}
+	return nil
+}
+
+// validate prod에서는 비밀 값이 비어있으면 실패한다 (resolveSecrets가 채우지 않는다)
+func (c TicketConfig) validate(prod bool) error {
+	if c.TTL <= 0 || c.PassTTL <= 0 {
+		return fmt.Errorf("ticket.ttl and ticket.passTTL must be greater than 0")
+	}
+	ids := make(map[string]bool, len(c.Keys))
+	for i, key := range c.Keys {
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			return fmt.Errorf("ticket.keys[%d].id: %q must be non-empty and must not contain '.'", i, key.ID)
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("ticket.keys[%d].id: duplicate key %q", i, key.ID)
+		}
+		ids[key.ID] = true
+		if key.Secret == "" {
+			if prod {
+				return fmt.Errorf("ticket.keys[%d].secret is empty; set %s or ticket.keys[%d].secret (env is %q)", i, secretSource(key), i, EnvProd)
+			}
+			return fmt.Errorf("ticket.keys[%d].secret is empty", i)
+		}
+		if key.Secret == placeholderTicketSecret {
+			return fmt.Errorf("ticket.keys[%d].secret is the example placeholder; generate a random secret", i)
+		}
+		if len(key.Secret) < minTicketSecret {
+			return fmt.Errorf("ticket.keys[%d].secret must be at least %d bytes", i, minTicketSecret)
+		}
+	}
+	if !ids[c.CurrentKey] {
+		return fmt.Errorf("ticket.currentKey: %q is not in ticket.keys", c.CurrentKey)
+	}
+	return nil
+}
+
+// secretSource 비밀 값을 어디에 넣어야 하는지 에러 메시지에 쓴다
+func secretSource(key TicketKey) string {
+	if key.SecretEnv != "" {
+		return "environment variable " + key.SecretEnv
+	}
+	return "secretEnv"
+}
+
+// validTargetURL 통과권을 붙여서 리다이렉트하므로 절대 주소여야 한다
+func validTargetURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an absolute http(s) URL", target)
+	}
+	return nil
+}
+
+// validRoomName 대기실 이름은 URL 경로와 레디스 키에 그대로 들어간다
+func validRoomName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
 }
 
 //설정파일 읽어오기
 //레디스 또는 저장소 셋업
 //대기열 관리 셋업
 //로그
+
+// Validate 알고리즘 이름과 각 블록의 값 범위를 확인한다
+func (c RateLimitConfig) Validate() error {
+	if err := c.LimiterConfig.validate("rateLimit"); err != nil {
+		return err
+	}
+	if err := c.PerClient.LimiterConfig.validate("rateLimit.perClient"); err != nil {
+		return err
+	}
+	if c.PerClient.IdleTimeout <= 0 {
+		return fmt.Errorf("rateLimit.perClient.idleTimeout must be greater than 0")
+	}
+	if _, err := c.PerClient.TrustedProxyPrefixes(); err != nil {
+		return err
+	}
+
+	if c.Adaptive.Enabled {
+		a := c.Adaptive
+		if strings.ToLower(c.Type) != TypeTokenBucket {
+			return fmt.Errorf("rateLimit.adaptive is only supported for %q, got %q", TypeTokenBucket, c.Type)
+		}
+		if a.MinRate <= 0 || a.MaxRate < a.MinRate {
+			return fmt.Errorf("rateLimit.adaptive: need 0 < minRate <= maxRate, got %v / %v", a.MinRate, a.MaxRate)
+		}
+		if a.IncreaseStep <= 0 {
+			return fmt.Errorf("rateLimit.adaptive.increaseStep must be greater than 0")
+		}
+		if a.DecreaseFactor <= 0 || a.DecreaseFactor >= 1 {
+			return fmt.Errorf("rateLimit.adaptive.decreaseFactor must be between 0 and 1")
+		}
+	}
+
+	if c.Concurrency.MaxInFlight <= 0 {
+		return fmt.Errorf("rateLimit.concurrency.maxInFlight must be greater than 0")
+	}
+	if c.Concurrency.MaxQueue < 0 || c.Concurrency.QueueTimeout < 0 {
+		return fmt.Errorf("rateLimit.concurrency.maxQueue / queueTimeout must not be negative")
+	}
+	return nil
+}
+
+func (c LimiterConfig) validate(path string) error {
+	switch strings.ToLower(c.Type) {
+	case TypeTokenBucket:
+		tb := c.TokenBucket
+		if tb.Capacity <= 0 || tb.TokensPerSecond <= 0 {
+			return fmt.Errorf("%s.tokenBucket: capacity and tokensPerSecond must be greater than 0", path)
+		}
+		if tb.Tokens < 0 || tb.Tokens > tb.Capacity {
+			return fmt.Errorf("%s.tokenBucket.tokens must be between 0 and capacity (%v)", path, tb.Capacity)
+		}
+	case TypeLeakyBucket:
+		if c.LeakyBucket.Capacity <= 0 || c.LeakyBucket.LeakRate <= 0 {
+			return fmt.Errorf("%s.leakyBucket: capacity and leakRate must be greater than 0", path)
+		}
+	case TypeFixedWindow:
+		if c.FixedWindow.WindowSize <= 0 || c.FixedWindow.Capacity <= 0 {
+			return fmt.Errorf("%s.fixedWindow: windowSize and capacity must be greater than 0", path)
+		}
+	case TypeSlidingWindow:
+		if c.SlidingWindow.Limit <= 0 || c.SlidingWindow.WindowSize <= 0 {
+			return fmt.Errorf("%s.slidingWindow: limit and windowSize must be greater than 0", path)
+		}
+	case TypeSlidingWindowCounter:
+		if c.SlidingWindowCounter.Limit <= 0 || c.SlidingWindowCounter.WindowSize <= 0 {
+			return fmt.Errorf("%s.slidingWindowCounter: limit and windowSize must be greater than 0", path)
+		}
+	case TypeGCRA:
+		if c.GCRA.Rate <= 0 || c.GCRA.Burst <= 0 {
+			return fmt.Errorf("%s.gcra: rate and burst must be greater than 0", path)
+		}
+	case TypeComposite:
+		if len(c.Limits) == 0 {
+			return fmt.Errorf("%s.limits: composite needs at least one limiter", path)
+		}
+		for i, limit := range c.Limits {
+			if err := limit.validate(fmt.Sprintf("%s.limits[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s.type: unknown limiter type %q (%s, %s, %s, %s, %s, %s, %s)", path, c.Type,
+			TypeTokenBucket, TypeLeakyBucket, TypeFixedWindow, TypeSlidingWindow, TypeSlidingWindowCounter, TypeGCRA, TypeComposite)
+	}
+
+	store := strings.ToLower(c.Store)
+	if store != "" && store != StoreMemory && store != StoreRedis {
+		return fmt.Errorf("%s.store: unknown store %q (memory, redis)", path, c.Store)
+	}
+	if store == StoreRedis && strings.ToLower(c.Type) != TypeTokenBucket {
+		return fmt.Errorf("%s.store: redis store is only supported for %q", path, TypeTokenBucket)
+	}
+	return nil
+}
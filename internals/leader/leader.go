@@ -0,0 +1,172 @@
+// Package leader 레디스 임대(lease)로 레플리카 중 한 곳만 일을 하도록 뽑는다
+//
+// 리더는 SET NX PX로 키를 잡고 ttl/3마다 만료 시간을 늘린다. 리더가 죽으면 키가 만료되고
+// 다음 갱신 주기에 다른 레플리카가 잡는다 (최대 ttl + ttl/3 동안 리더가 없다)
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// renewScript 내가 잡은 임대일 때만 만료 시간을 늘린다
+// KEYS[1] 임대 키, ARGV[1] 인스턴스 ID, ARGV[2] ttl(ms)
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript 내가 잡은 임대일 때만 지운다
+// KEYS[1] 임대 키, ARGV[1] 인스턴스 ID
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// releaseTimeout 종료할 때 임대를 돌려주는 데 쓰는 시간
+const releaseTimeout = time.Second
+
+// Observer 리더가 되거나 리더에서 물러날 때 불린다
+type Observer interface {
+	LeadershipChanged(leader bool)
+}
+
+// Elector key 하나에 대한 리더 선출
+type Elector struct {
+	rdb      *redis.Client
+	key      string
+	id       string
+	ttl      time.Duration
+	observer Observer
+	shutdown chan struct{}
+	done     chan struct{}
+	started  atomic.Bool
+	stopOnce sync.Once
+
+	// deadline 이 시각(unix ns)까지는 리더다. 갱신에 실패해도 임대가 남아있는 동안은 리더로 본다
+	deadline atomic.Int64
+}
+
+// NewElector id는 레플리카마다 달라야 한다. observer는 nil이어도 된다
+func NewElector(rdb *redis.Client, key, id string, ttl time.Duration, observer Observer) *Elector {
+	return &Elector{
+		rdb:      rdb,
+		key:      key,
+		id:       id,
+		ttl:      ttl,
+		observer: observer,
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// IsLeader 지금 임대를 가지고 있으면 true
+func (e *Elector) IsLeader() bool {
+	return time.Now().UnixNano() < e.deadline.Load()
+}
+
+// Start Stop이 불리거나 ctx가 끝날 때까지 임대를 잡거나 갱신한다. 끝나면 임대를 돌려준다
+// 두 번째 호출은 바로 돌아온다
+func (e *Elector) Start(ctx context.Context) {
+	if !e.started.CompareAndSwap(false, true) {
+		return
+	}
+	defer close(e.done)
+	select {
+	case <-e.shutdown:
+		// Start 전에 Stop이 불렸다
+		return
+	default:
+	}
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	defer e.release()
+
+	leader := false
+	for {
+		e.campaign(ctx)
+
+		if now := e.IsLeader(); now != leader {
+			leader = now
+			log.Printf("Leadership of %s changed: leader=%v", e.key, leader)
+			if e.observer != nil {
+				e.observer.LeadershipChanged(leader)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign 리더면 임대를 늘리고, 아니면 잡아본다
+func (e *Elector) campaign(ctx context.Context) {
+	// 레디스가 만료 시간을 세기 시작하는 것은 명령을 받은 뒤이므로 보내기 전 시각부터 세면 안전하다
+	start := time.Now()
+
+	var ok bool
+	var err error
+	if e.IsLeader() {
+		var n int64
+		n, err = renewScript.Run(ctx, e.rdb, []string{e.key}, e.id, e.ttl.Milliseconds()).Int64()
+		ok = n == 1
+	} else {
+		ok, err = e.rdb.SetNX(ctx, e.key, e.id, e.ttl).Result()
+	}
+
+	if err != nil {
+		// 결과를 모르면 남은 임대 시간까지만 리더로 본다
+		if ctx.Err() == nil {
+			log.Printf("Error renewing lease %s: %v", e.key, err)
+		}
+		return
+	}
+	if ok {
+		e.deadline.Store(start.Add(e.ttl).UnixNano())
+	} else {
+		e.deadline.Store(0)
+	}
+}
+
+// release 다른 레플리카가 만료를 기다리지 않고 바로 이어받게 한다
+func (e *Elector) release() {
+	if !e.IsLeader() {
+		return
+	}
+	e.deadline.Store(0)
+	if e.observer != nil {
+		e.observer.LeadershipChanged(false)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := releaseScript.Run(ctx, e.rdb, []string{e.key}, e.id).Err(); err != nil {
+		log.Printf("Error releasing lease %s: %v", e.key, err)
+	}
+}
+
+// Stop Start 루프를 멈추고 임대를 돌려줄 때까지 기다린다
+// Start가 돌지 않았으면 기다리지 않는다. 여러 번 불러도 된다
+func (e *Elector) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.shutdown)
+	})
+	if e.started.Load() {
+		<-e.done
+	}
+}
@@ -0,0 +1,154 @@
+package limiters
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	AdaptiveIncrease = "increase"
+	AdaptiveDecrease = "decrease"
+)
+
+// AdaptiveTarget AdaptiveLimiter가 속도를 조정할 토큰 버킷 (TokenBucket, RedisTokenBucket)
+type AdaptiveTarget interface {
+	Reserver
+	BatchLimiter
+	reserver
+	batchReserver
+	Config() (capacity, tokensPerSecond float32)
+	UpdateConfig(capacity, refillRate float32) error
+}
+
+// AdaptiveObserver 속도가 바뀔 때마다 불린다. direction은 처음 한 번은 빈 문자열, 이후 AdaptiveIncrease / AdaptiveDecrease
+type AdaptiveObserver interface {
+	RateAdjusted(rate float32, direction string)
+}
+
+type AdaptiveConfig struct {
+	MinRate        float32       // tokensPerSecond 하한
+	MaxRate        float32       // tokensPerSecond 상한
+	IncreaseStep   float32       // 정상 응답이면 더할 값
+	DecreaseFactor float32       // 실패하거나 느리면 곱할 값 (0~1)
+	LatencyTarget  time.Duration // 이보다 느린 응답은 실패로 본다. 0이면 지연은 보지 않는다
+	Interval       time.Duration // 같은 방향으로 다시 조정하기까지 최소 간격
+}
+
+func (c AdaptiveConfig) validate() error {
+	if c.MinRate <= 0 || c.MaxRate < c.MinRate {
+		return fmt.Errorf("adaptive: need 0 < minRate <= maxRate, got %v / %v", c.MinRate, c.MaxRate)
+	}
+	if c.IncreaseStep <= 0 {
+		return fmt.Errorf("adaptive: increaseStep must be greater than 0")
+	}
+	if c.DecreaseFactor <= 0 || c.DecreaseFactor >= 1 {
+		return fmt.Errorf("adaptive: decreaseFactor must be between 0 and 1")
+	}
+	return nil
+}
+
+// AdaptiveLimiter 보호하는 서비스의 응답(지연, 성공 여부)을 보고
+// 토큰 버킷의 tokensPerSecond를 AIMD(additive-increase / multiplicative-decrease)로 조정한다
+type AdaptiveLimiter struct {
+	AdaptiveTarget
+	cfg      AdaptiveConfig
+	observer AdaptiveObserver
+
+	mu           sync.Mutex
+	capacity     float32
+	rate         float32
+	lastIncrease time.Time
+	lastDecrease time.Time
+}
+
+// NewAdaptiveLimiter observer는 nil이어도 된다
+func NewAdaptiveLimiter(target AdaptiveTarget, cfg AdaptiveConfig, observer AdaptiveObserver) (*AdaptiveLimiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	capacity, rate := target.Config()
+	rate = min(max(rate, cfg.MinRate), cfg.MaxRate)
+	if err := target.UpdateConfig(capacity, rate); err != nil {
+		return nil, err
+	}
+
+	al := &AdaptiveLimiter{
+		AdaptiveTarget: target,
+		cfg:            cfg,
+		observer:       observer,
+		capacity:       capacity,
+		rate:           rate,
+	}
+	if observer != nil {
+		observer.RateAdjusted(rate, "")
+	}
+	return al, nil
+}
+
+// Report 보호하는 서비스에 보낸 요청 하나의 결과를 알린다
+func (al *AdaptiveLimiter) Report(latency time.Duration, success bool) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	// 버킷을 공유하는 다른 레플리카가 바꾼 설정(RedisTokenBucket)에서부터 조정한다
+	al.capacity, al.rate = al.AdaptiveTarget.Config()
+	al.rate = min(max(al.rate, al.cfg.MinRate), al.cfg.MaxRate)
+
+	now := time.Now()
+	rate := al.rate
+	direction := AdaptiveIncrease
+	if !success || (al.cfg.LatencyTarget > 0 && latency > al.cfg.LatencyTarget) {
+		// 한 번의 장애로 연달아 줄어들지 않도록 간격을 둔다
+		if now.Sub(al.lastDecrease) < al.cfg.Interval {
+			return
+		}
+		rate = max(al.cfg.MinRate, rate*al.cfg.DecreaseFactor)
+		direction = AdaptiveDecrease
+		al.lastDecrease = now
+	} else {
+		if now.Sub(al.lastIncrease) < al.cfg.Interval {
+			return
+		}
+		rate = min(al.cfg.MaxRate, rate+al.cfg.IncreaseStep)
+		al.lastIncrease = now
+	}
+
+	if rate == al.rate {
+		return
+	}
+	if err := al.AdaptiveTarget.UpdateConfig(al.capacity, rate); err != nil {
+		return
+	}
+	al.rate = rate
+
+	if al.observer != nil {
+		al.observer.RateAdjusted(rate, direction)
+	}
+}
+
+// Rate 현재 tokensPerSecond
+func (al *AdaptiveLimiter) Rate() float32 {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.rate
+}
+
+// UpdateConfig 직접 설정을 바꾸면 그 값에서부터 다시 조정한다. 속도는 하한/상한 안으로 자른다
+func (al *AdaptiveLimiter) UpdateConfig(capacity, refillRate float32) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	rate := min(max(refillRate, al.cfg.MinRate), al.cfg.MaxRate)
+	if err := al.AdaptiveTarget.UpdateConfig(capacity, rate); err != nil {
+		return err
+	}
+	al.capacity = capacity
+	al.rate = rate
+
+	if al.observer != nil {
+		al.observer.RateAdjusted(rate, "")
+	}
+	return nil
+}
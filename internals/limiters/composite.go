@@ -0,0 +1,122 @@
+package limiters
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CompositeLimiter 여러 리미터를 모두 통과해야 허용한다 (예: 초당 10회 그리고 시간당 5000회)
+// 앞의 리미터에서 토큰을 가져간 뒤 뒤의 리미터가 거절하면 앞에서 가져간 토큰을 돌려준다
+type CompositeLimiter struct {
+	limiters []RateLimiter
+}
+
+// NewCompositeLimiter 마지막을 제외한 리미터는 토큰을 돌려줄 수 있어야 한다(Reserve 지원)
+// 마지막 리미터는 뒤에 거절할 리미터가 없으므로 Allow만 있어도 된다
+// ConcurrencyLimiter는 받지 않는다. 허용한 뒤 Release를 부를 곳이 없어서 자리가 새기 때문이다
+func NewCompositeLimiter(limiters ...RateLimiter) (*CompositeLimiter, error) {
+	if len(limiters) == 0 {
+		return nil, fmt.Errorf("composite: at least one limiter is required")
+	}
+	for i, rl := range limiters {
+		if _, ok := rl.(*ConcurrencyLimiter); ok {
+			return nil, fmt.Errorf("composite: limiter %d is a ConcurrencyLimiter, which needs Release after each call; use it on its own", i)
+		}
+	}
+	for i, rl := range limiters[:len(limiters)-1] {
+		if _, ok := rl.(reserver); !ok {
+			return nil, fmt.Errorf("composite: limiter %d (%T) cannot roll back tokens", i, rl)
+		}
+	}
+
+	return &CompositeLimiter{
+		limiters: limiters,
+	}, nil
+}
+
+func (cl *CompositeLimiter) Allow(tokens int) bool {
+	return cl.reserveN(tokens, 0).OK()
+}
+
+// AllowRetry 거절한 경우 가장 늦게 토큰이 생기는 리미터 기준으로 재시도 시간을 돌려준다
+func (cl *CompositeLimiter) AllowRetry(tokens int) (bool, time.Duration) {
+	now := time.Now()
+	r := cl.reserveN(tokens, 0)
+	if r.OK() {
+		return true, 0
+	}
+	return false, retryAfter(now, reserveResult{timeToAct: r.timeToAct})
+}
+
+// Reserve 모든 리미터에서 예약한다. Delay는 가장 늦게 토큰이 생기는 리미터 기준
+func (cl *CompositeLimiter) Reserve(tokens int) *Reservation {
+	return cl.reserveN(tokens, InfDuration)
+}
+
+func (cl *CompositeLimiter) Wait(ctx context.Context, tokens int) error {
+	return wait(ctx, cl, tokens)
+}
+
+func (cl *CompositeLimiter) reserveN(tokens int, maxWait time.Duration) *Reservation {
+	parts := make(reservationGroup, 0, len(cl.limiters))
+	r := &Reservation{
+		tokens: tokens,
+		lim:    &parts,
+	}
+	if tokens <= 0 {
+		return r
+	}
+
+	now := time.Now()
+	for _, rl := range cl.limiters {
+		res, ok := rl.(reserver)
+		if !ok {
+			// 마지막 리미터. 앞의 리미터가 모두 바로 허용한 경우에만 쓸 수 있다
+			if r.timeToAct.After(now) {
+				parts.cancelReservation(r)
+				return r
+			}
+			if !rl.Allow(tokens) {
+				parts.cancelReservation(r)
+				r.timeToAct = time.Time{}
+				return r
+			}
+			break
+		}
+
+		part := res.reserveN(tokens, maxWait)
+		if !part.OK() {
+			parts.cancelReservation(r)
+			r.timeToAct = part.timeToAct
+			return r
+		}
+		parts = append(parts, part)
+		if part.timeToAct.After(r.timeToAct) {
+			r.timeToAct = part.timeToAct
+		}
+	}
+
+	if r.timeToAct.Before(now) {
+		r.timeToAct = now
+	}
+	r.ok = true
+	return r
+}
+
+// Stop 모든 리미터를 멈춘다
+func (cl *CompositeLimiter) Stop() {
+	for _, rl := range cl.limiters {
+		rl.Stop()
+	}
+}
+
+// reservationGroup 리미터마다 나눠서 잡은 예약. 취소하면 모두 돌려준다
+type reservationGroup []*Reservation
+
+func (g *reservationGroup) cancelReservation(r *Reservation) {
+	for _, part := range *g {
+		part.Cancel()
+	}
+	*g = (*g)[:0]
+}
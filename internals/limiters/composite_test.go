@@ -0,0 +1,49 @@
+package limiters
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompositeRejectsConcurrencyLimiter(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		build func(cl *ConcurrencyLimiter) []RateLimiter
+	}{
+		{"first", func(cl *ConcurrencyLimiter) []RateLimiter { return []RateLimiter{cl, NewSyncTokenBucket(10, 10, 10)} }},
+		{"last", func(cl *ConcurrencyLimiter) []RateLimiter { return []RateLimiter{NewSyncTokenBucket(10, 10, 10), cl} }},
+		{"only", func(cl *ConcurrencyLimiter) []RateLimiter { return []RateLimiter{cl} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limiters := tc.build(NewConcurrencyLimiter(2, 0, 0))
+			defer stopAll(limiters)
+
+			if _, err := NewCompositeLimiter(limiters...); err == nil {
+				t.Fatal("NewCompositeLimiter accepted a ConcurrencyLimiter")
+			}
+		})
+	}
+}
+
+// 동시 처리 제한은 합성하지 않고 Allow와 Release를 짝지어 쓰면 자리가 새지 않는다
+func TestConcurrencyLimiterAllowReleaseDoesNotLeak(t *testing.T) {
+	const maxInFlight = 3
+	cl := NewConcurrencyLimiter(maxInFlight, 0, time.Second)
+	defer cl.Stop()
+
+	for i := 0; i < maxInFlight*10; i++ {
+		if !cl.Allow(1) {
+			t.Fatalf("call %d rejected after %d Allow/Release pairs", i, i)
+		}
+		cl.Release(1)
+	}
+
+	for i := 0; i < maxInFlight; i++ {
+		if !cl.Allow(1) {
+			t.Fatalf("slot %d rejected below maxInFlight", i)
+		}
+	}
+	if cl.Allow(1) {
+		t.Fatal("allowed more than maxInFlight at once")
+	}
+}
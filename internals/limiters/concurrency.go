@@ -0,0 +1,190 @@
+package limiters
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull 대기열이 가득 차서 기다릴 수도 없는 경우
+	ErrQueueFull = errors.New("limiters: concurrency queue is full")
+	// ErrQueueTimeout 대기 시간 안에 자리가 나지 않은 경우
+	ErrQueueTimeout = errors.New("limiters: timed out waiting for concurrency slot")
+	// ErrLimiterStopped 멈춘 리미터에 요청한 경우
+	ErrLimiterStopped = errors.New("limiters: limiter stopped")
+)
+
+// ConcurrencyLimiter 시간당 요청 수가 아니라 동시에 처리 중인 요청 수를 제한한다
+// Acquire로 자리를 잡고 하위 호출이 끝나면 Release로 돌려준다
+// 자리가 없으면 최대 maxQueue명까지 도착 순서대로 queueTimeout 동안 기다린다
+type ConcurrencyLimiter struct {
+	maxInFlight  int
+	maxQueue     int
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	waiters  list.List // *concurrencyWaiter, 앞쪽이 먼저 온 요청
+	stopped  bool
+}
+
+type concurrencyWaiter struct {
+	tokens int
+	ready  chan struct{} // 자리를 넘겨받으면 닫힌다
+}
+
+// NewConcurrencyLimiter maxQueue가 0이면 기다리지 않고 바로 거절한다
+func NewConcurrencyLimiter(maxInFlight, maxQueue int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		maxInFlight:  maxInFlight,
+		maxQueue:     maxQueue,
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Allow 자리가 있으면 바로 tokens개를 잡는다. 잡은 자리는 반드시 Release로 돌려줘야 한다
+func (cl *ConcurrencyLimiter) Allow(tokens int) bool {
+	if tokens <= 0 {
+		return false
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.tryAcquire(tokens)
+}
+
+// tryAcquire 기다리는 요청이 없고 자리가 있으면 잡는다. cl.mu를 잡은 상태에서 호출
+func (cl *ConcurrencyLimiter) tryAcquire(tokens int) bool {
+	if cl.stopped || cl.waiters.Len() > 0 || cl.inFlight+tokens > cl.maxInFlight {
+		return false
+	}
+	cl.inFlight += tokens
+	return true
+}
+
+// Acquire tokens개의 자리를 잡을 때까지 queueTimeout 또는 ctx가 끝날 때까지 기다린다
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, tokens int) error {
+	cl.mu.Lock()
+	if tokens <= 0 || tokens > cl.maxInFlight {
+		cl.mu.Unlock()
+		return fmt.Errorf("limiters: cannot acquire %d slots (max in-flight %d)", tokens, cl.maxInFlight)
+	}
+	if cl.stopped {
+		cl.mu.Unlock()
+		return ErrLimiterStopped
+	}
+	if cl.tryAcquire(tokens) {
+		cl.mu.Unlock()
+		return nil
+	}
+	if cl.waiters.Len() >= cl.maxQueue {
+		cl.mu.Unlock()
+		return ErrQueueFull
+	}
+	waiter := &concurrencyWaiter{
+		tokens: tokens,
+		ready:  make(chan struct{}),
+	}
+	elem := cl.waiters.PushBack(waiter)
+	queueTimeout := cl.queueTimeout
+	cl.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if queueTimeout > 0 {
+		timer := time.NewTimer(queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	cl.mu.Lock()
+	select {
+	case <-waiter.ready:
+		// 포기하는 사이에 자리를 넘겨받았으면 돌려준다
+		cl.inFlight -= tokens
+		cl.notifyWaiters()
+	default:
+		isFront := cl.waiters.Front() == elem
+		cl.waiters.Remove(elem)
+		// 맨 앞에서 막고 있었다면 뒤에 기다리는 요청이 들어갈 수 있는지 확인
+		if isFront {
+			cl.notifyWaiters()
+		}
+	}
+	cl.mu.Unlock()
+
+	return err
+}
+
+// Release Allow나 Acquire로 잡은 자리를 돌려준다
+func (cl *ConcurrencyLimiter) Release(tokens int) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.inFlight -= tokens
+	if cl.inFlight < 0 {
+		cl.inFlight = 0
+	}
+	cl.notifyWaiters()
+}
+
+// notifyWaiters 앞에서부터 자리가 나는 만큼 기다리는 요청을 깨운다. cl.mu를 잡은 상태에서 호출
+func (cl *ConcurrencyLimiter) notifyWaiters() {
+	for {
+		front := cl.waiters.Front()
+		if front == nil {
+			return
+		}
+		waiter := front.Value.(*concurrencyWaiter)
+		if cl.inFlight+waiter.tokens > cl.maxInFlight {
+			return
+		}
+		cl.inFlight += waiter.tokens
+		cl.waiters.Remove(front)
+		close(waiter.ready)
+	}
+}
+
+func (cl *ConcurrencyLimiter) UpdateConfig(maxInFlight, maxQueue int, queueTimeout time.Duration) error {
+	if maxInFlight <= 0 || maxQueue < 0 || queueTimeout < 0 {
+		return fmt.Errorf("maxInFlight must be greater than 0, maxQueue / queueTimeout must not be negative")
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.maxInFlight = maxInFlight
+	cl.maxQueue = maxQueue
+	cl.queueTimeout = queueTimeout
+	// 자리가 늘었으면 기다리던 요청을 들여보낸다
+	cl.notifyWaiters()
+
+	return nil
+}
+
+// InFlight 지금 처리 중인 요청 수
+func (cl *ConcurrencyLimiter) InFlight() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.inFlight
+}
+
+// Stop 새 요청을 더 받지 않는다. 이미 기다리고 있는 요청은 타임아웃까지 기다린다
+func (cl *ConcurrencyLimiter) Stop() {
+	cl.mu.Lock()
+	cl.stopped = true
+	cl.mu.Unlock()
+}
@@ -0,0 +1,164 @@
+package limiters
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/takaxis2/rate-limiter/internals/config"
+)
+
+// NewFromConfig 설정의 type에 맞는 리미터를 만든다
+// 메모리 저장소는 전담 고루틴이 없는 Sync 구현을 쓰고, key는 레디스 저장소의 버킷 키로 쓴다
+// 값의 범위는 config.Load에서 검사한다
+func NewFromConfig(ctx context.Context, cfg config.LimiterConfig, rdb *redis.Client, key string) (RateLimiter, error) {
+	switch strings.ToLower(cfg.Type) {
+	case config.TypeTokenBucket:
+		tb := cfg.TokenBucket
+		if strings.ToLower(cfg.Store) == config.StoreRedis {
+			if rdb == nil {
+				return nil, fmt.Errorf("limiter %q: redis store requires a redis client", cfg.Type)
+			}
+			return NewRedisTokenBucket(ctx, rdb, key, float32(tb.Capacity), float32(tb.TokensPerSecond)), nil
+		}
+		return NewSyncTokenBucket(float32(tb.Capacity), float32(tb.TokensPerSecond), float32(tb.Tokens)), nil
+
+	case config.TypeLeakyBucket:
+		return NewSyncLeakyBucket(cfg.LeakyBucket.Capacity, cfg.LeakyBucket.LeakRate), nil
+
+	case config.TypeFixedWindow:
+		return NewSyncFixedWindow(cfg.FixedWindow.WindowSize, cfg.FixedWindow.Capacity), nil
+
+	case config.TypeSlidingWindow:
+		return NewSyncSlidingWindow(cfg.SlidingWindow.Limit, cfg.SlidingWindow.WindowSize), nil
+
+	case config.TypeSlidingWindowCounter:
+		return NewSyncSlidingWindowCounter(cfg.SlidingWindowCounter.Limit, cfg.SlidingWindowCounter.WindowSize), nil
+
+	case config.TypeGCRA:
+		return NewSyncGCRA(float32(cfg.GCRA.Rate), cfg.GCRA.Burst), nil
+
+	case config.TypeComposite:
+		limits := make([]RateLimiter, 0, len(cfg.Limits))
+		for i, limitCfg := range cfg.Limits {
+			rl, err := NewFromConfig(ctx, limitCfg, rdb, fmt.Sprintf("%s:%d", key, i))
+			if err != nil {
+				stopAll(limits)
+				return nil, err
+			}
+			limits = append(limits, rl)
+		}
+
+		cl, err := NewCompositeLimiter(limits...)
+		if err != nil {
+			stopAll(limits)
+			return nil, err
+		}
+		return cl, nil
+
+	default:
+		return nil, fmt.Errorf("unknown limiter type %q", cfg.Type)
+	}
+}
+
+// CanReconfigure Reconfigure로 cfg를 적용할 수 있는지 리미터를 바꾸지 않고 확인한다
+// 여러 리미터에 나눠 적용할 때 먼저 모두 확인해서 일부만 바뀌는 일이 없게 한다
+func CanReconfigure(rl RateLimiter, cfg config.LimiterConfig) error {
+	typ, redisStore := kindOf(rl)
+	wantType, wantRedis := strings.ToLower(cfg.Type), strings.ToLower(cfg.Store) == config.StoreRedis
+	if typ != wantType || redisStore != wantRedis {
+		return fmt.Errorf("limiter changed from %s (redis=%v) to %s (redis=%v), restart required", typ, redisStore, wantType, wantRedis)
+	}
+
+	if l, ok := rl.(*CompositeLimiter); ok {
+		if len(l.limiters) != len(cfg.Limits) {
+			return fmt.Errorf("composite limiter changed from %d to %d limits, restart required", len(l.limiters), len(cfg.Limits))
+		}
+		for i, child := range l.limiters {
+			if err := CanReconfigure(child, cfg.Limits[i]); err != nil {
+				return fmt.Errorf("limits[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Reconfigure 실행 중인 리미터에 새 설정 값을 적용한다
+// 알고리즘이나 저장소가 바뀐 경우에는 상태를 옮길 수 없으므로 에러를 돌려준다 (재시작 필요)
+func Reconfigure(rl RateLimiter, cfg config.LimiterConfig) error {
+	if err := CanReconfigure(rl, cfg); err != nil {
+		return err
+	}
+
+	switch l := rl.(type) {
+	case interface {
+		UpdateConfig(capacity, refillRate float32) error
+	}:
+		// TokenBucket, RedisTokenBucket, AdaptiveLimiter
+		return l.UpdateConfig(float32(cfg.TokenBucket.Capacity), float32(cfg.TokenBucket.TokensPerSecond))
+	case *LeakyBucket:
+		return l.UpdateConfig(cfg.LeakyBucket.Capacity, cfg.LeakyBucket.LeakRate)
+	case *FixedWindow:
+		return l.UpdateConfig(cfg.FixedWindow.WindowSize, cfg.FixedWindow.Capacity)
+	case *SlidingWindow:
+		return l.UpdateConfig(cfg.SlidingWindow.Limit, cfg.SlidingWindow.WindowSize)
+	case *SlidingWindowCounter:
+		return l.UpdateConfig(cfg.SlidingWindowCounter.Limit, cfg.SlidingWindowCounter.WindowSize)
+	case *GCRA:
+		return l.UpdateConfig(float32(cfg.GCRA.Rate), cfg.GCRA.Burst)
+	case *CompositeLimiter:
+		for i, child := range l.limiters {
+			if err := Reconfigure(child, cfg.Limits[i]); err != nil {
+				return fmt.Errorf("limits[%d]: %w", i, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("limiter %T cannot be reconfigured", rl)
+	}
+}
+
+// kindOf 리미터의 설정 type 이름과 레디스 저장소 여부
+func kindOf(rl RateLimiter) (string, bool) {
+	switch l := rl.(type) {
+	case *TokenBucket:
+		return config.TypeTokenBucket, false
+	case *RedisTokenBucket:
+		return config.TypeTokenBucket, true
+	case *AdaptiveLimiter:
+		return kindOf(l.AdaptiveTarget)
+	case *LeakyBucket:
+		return config.TypeLeakyBucket, false
+	case *FixedWindow:
+		return config.TypeFixedWindow, false
+	case *SlidingWindow:
+		return config.TypeSlidingWindow, false
+	case *SlidingWindowCounter:
+		return config.TypeSlidingWindowCounter, false
+	case *GCRA:
+		return config.TypeGCRA, false
+	case *CompositeLimiter:
+		return config.TypeComposite, false
+	default:
+		return fmt.Sprintf("%T", rl), false
+	}
+}
+
+// AdaptiveConfigFrom 설정 파일 값을 AdaptiveConfig로 옮긴다
+func AdaptiveConfigFrom(cfg config.AdaptiveConfig) AdaptiveConfig {
+	return AdaptiveConfig{
+		MinRate:        float32(cfg.MinRate),
+		MaxRate:        float32(cfg.MaxRate),
+		IncreaseStep:   float32(cfg.IncreaseStep),
+		DecreaseFactor: float32(cfg.DecreaseFactor),
+		LatencyTarget:  cfg.LatencyTarget,
+		Interval:       cfg.Interval,
+	}
+}
+
+func stopAll(limiters []RateLimiter) {
+	for _, rl := range limiters {
+		rl.Stop()
+	}
+}
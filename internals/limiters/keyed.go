@@ -0,0 +1,196 @@
+package limiters
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// KeyedLimiter 키(유저 ID, API 키, 클라이언트 IP 등)마다 별도의 RateLimiter를 둔다
+// 리미터는 처음 요청이 들어올 때 newLimiter로 만들고,
+// idleTimeout 동안 쓰이지 않았거나 maxKeys를 넘으면 가장 오래 안 쓰인 것부터 정리한다
+type KeyedLimiter struct {
+	newLimiter  func(key string) RateLimiter
+	idleTimeout time.Duration
+	maxKeys     int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // 앞쪽일수록 최근에 쓰인 키
+
+	stopFunc context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+type keyedEntry struct {
+	key      string
+	limiter  RateLimiter
+	lastUsed time.Time
+}
+
+// NewKeyedLimiter maxKeys가 0 이하이면 키 개수 제한 없이 idleTimeout으로만 정리한다
+func NewKeyedLimiter(ctx context.Context, newLimiter func(key string) RateLimiter, idleTimeout time.Duration, maxKeys int) *KeyedLimiter {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	kl := &KeyedLimiter{
+		newLimiter:  newLimiter,
+		idleTimeout: idleTimeout,
+		maxKeys:     maxKeys,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		stopFunc:    cancelFunc,
+	}
+
+	kl.wg.Add(1)
+	go kl.evictIdle(ctx)
+
+	return kl
+}
+
+// AllowKey key에 해당하는 리미터에서 tokens만큼 요청
+func (kl *KeyedLimiter) AllowKey(key string, tokens int) bool {
+	return kl.get(key).Allow(tokens)
+}
+
+// AllowKeyRetry AllowKey와 같지만 키의 리미터가 RetryAfterLimiter이면 재시도까지 남은 시간도 돌려준다
+func (kl *KeyedLimiter) AllowKeyRetry(key string, tokens int) (bool, time.Duration) {
+	rl := kl.get(key)
+	if rrl, ok := rl.(RetryAfterLimiter); ok {
+		return rrl.AllowRetry(tokens)
+	}
+	return rl.Allow(tokens), 0
+}
+
+// Each 현재 메모리에 있는 모든 키의 리미터에 fn을 실행한다. 설정을 바꿀 때 쓴다
+func (kl *KeyedLimiter) Each(fn func(key string, rl RateLimiter)) {
+	kl.mu.Lock()
+	entries := make([]*keyedEntry, 0, kl.lru.Len())
+	for elem := kl.lru.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*keyedEntry))
+	}
+	kl.mu.Unlock()
+
+	for _, entry := range entries {
+		fn(entry.key, entry.limiter)
+	}
+}
+
+// Len 현재 메모리에 있는 키 개수
+func (kl *KeyedLimiter) Len() int {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return kl.lru.Len()
+}
+
+func (kl *KeyedLimiter) get(key string) RateLimiter {
+	if rl, ok := kl.touch(key); ok {
+		return rl
+	}
+
+	// newLimiter는 락 밖에서 부른다. 느리거나 패닉이 나도 다른 키의 요청이 막히지 않는다
+	created := kl.newLimiter(key)
+
+	kl.mu.Lock()
+	if elem, ok := kl.entries[key]; ok {
+		// 그 사이 다른 요청이 먼저 만들었다
+		entry := elem.Value.(*keyedEntry)
+		entry.lastUsed = time.Now()
+		kl.lru.MoveToFront(elem)
+		kl.mu.Unlock()
+		created.Stop()
+		return entry.limiter
+	}
+
+	entry := &keyedEntry{
+		key:      key,
+		limiter:  created,
+		lastUsed: time.Now(),
+	}
+	kl.entries[key] = kl.lru.PushFront(entry)
+
+	var evicted []RateLimiter
+	for kl.maxKeys > 0 && kl.lru.Len() > kl.maxKeys {
+		evicted = append(evicted, kl.removeOldest())
+	}
+	kl.mu.Unlock()
+
+	// Stop은 리미터 고루틴 종료를 기다리므로 락 밖에서 호출
+	for _, rl := range evicted {
+		rl.Stop()
+	}
+	return entry.limiter
+}
+
+// touch 이미 있는 키면 최근에 쓴 것으로 표시하고 리미터를 돌려준다
+func (kl *KeyedLimiter) touch(key string) (RateLimiter, bool) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	elem, ok := kl.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*keyedEntry)
+	entry.lastUsed = time.Now()
+	kl.lru.MoveToFront(elem)
+	return entry.limiter, true
+}
+
+// removeOldest 가장 오래 안 쓰인 키를 제거. kl.mu를 잡은 상태에서 호출
+func (kl *KeyedLimiter) removeOldest() RateLimiter {
+	elem := kl.lru.Back()
+	entry := elem.Value.(*keyedEntry)
+	kl.lru.Remove(elem)
+	delete(kl.entries, entry.key)
+	return entry.limiter
+}
+
+func (kl *KeyedLimiter) evictIdle(ctx context.Context) {
+	defer kl.wg.Done()
+
+	interval := kl.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var evicted []RateLimiter
+			kl.mu.Lock()
+			for kl.lru.Len() > 0 {
+				entry := kl.lru.Back().Value.(*keyedEntry)
+				if now.Sub(entry.lastUsed) < kl.idleTimeout {
+					break
+				}
+				evicted = append(evicted, kl.removeOldest())
+			}
+			kl.mu.Unlock()
+
+			for _, rl := range evicted {
+				rl.Stop()
+			}
+		}
+	}
+}
+
+// Stop 정리 고루틴과 남아있는 모든 리미터를 멈춘다
+func (kl *KeyedLimiter) Stop() {
+	kl.stopFunc()
+	kl.wg.Wait()
+
+	kl.mu.Lock()
+	var evicted []RateLimiter
+	for kl.lru.Len() > 0 {
+		evicted = append(evicted, kl.removeOldest())
+	}
+	kl.mu.Unlock()
+
+	for _, rl := range evicted {
+		rl.Stop()
+	}
+}
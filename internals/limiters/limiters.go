@@ -3,6 +3,7 @@ package limiters
 import (
 	"context"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 )
@@ -14,47 +15,327 @@ type RateLimiter interface {
 	Stop()
 }
 
-type requestTokensCh struct {
+// DenyAll 모든 요청을 거절한다. 설정으로 리미터를 만들 수 없을 때 nil 대신 돌려준다 (fail closed)
+type DenyAll struct{}
+
+func (DenyAll) Allow(int) bool { return false }
+
+func (DenyAll) Stop() {}
+
+// Reserver 토큰이 생길 때까지 기다리거나(Wait) 미리 예약(Reserve)할 수 있는 RateLimiter
+type Reserver interface {
+	RateLimiter
+	Reserve(int) *Reservation
+	Wait(context.Context, int) error
+}
+
+// RetryAfterLimiter 거절했을 때 언제 다시 시도하면 되는지 알려주는 RateLimiter
+// retryAfter가 0인데 거절됐다면 기다려도 허용되지 않는 요청(용량 초과, 멈춘 리미터)이다
+type RetryAfterLimiter interface {
+	RateLimiter
+	AllowRetry(int) (ok bool, retryAfter time.Duration)
+}
+
+// BatchLimiter 지금 쓸 수 있는 토큰을 max개까지 한 번에 가져간다
+// 대기열 워커가 틱마다 여러 명을 입장시킬 때 쓴다
+type BatchLimiter interface {
+	RateLimiter
+	AllowUpTo(max int) int
+}
+
+// AllowUpTo rl에서 바로 쓸 수 있는 토큰을 max개까지 가져가고 가져간 수를 돌려준다
+// BatchLimiter가 아니면 거절될 때까지 Allow(1)을 반복한다
+func AllowUpTo(rl RateLimiter, max int) int {
+	if bl, ok := rl.(BatchLimiter); ok {
+		return bl.AllowUpTo(max)
+	}
+	granted := 0
+	for granted < max && rl.Allow(1) {
+		granted++
+	}
+	return granted
+}
+
+// batchReserver AllowUpTo로 가져간 토큰을 예약 하나로 돌려받는다. 쓰지 않은 토큰을 돌려줄 때 쓴다
+type batchReserver interface {
+	reserveUpTo(max int) *Reservation
+}
+
+// BatchReservation ReserveUpTo로 가져간 토큰. 다 쓰지 못했으면 남은 만큼 Refund로 돌려준다
+type BatchReservation struct {
 	tokens int
-	resCh  chan bool
+	// parts 돌려줄 수 있는 예약. batchReserver면 하나, 토큰마다 예약한 경우 토큰 수만큼, 돌려줄 수 없는 리미터면 비어있다
+	parts []*Reservation
+}
+
+// Tokens 아직 돌려주지 않은 토큰 수
+func (b *BatchReservation) Tokens() int {
+	return b.tokens
+}
+
+// Refund 가져간 토큰 중 n개를 돌려준다. 돌려줄 수 없는 리미터면 아무것도 하지 않는다
+func (b *BatchReservation) Refund(n int) {
+	n = min(n, b.tokens)
+	if n <= 0 {
+		return
+	}
+	b.tokens -= n
+
+	if len(b.parts) == 1 && b.parts[0].tokens > 1 {
+		// 한 번에 가져간 예약에서 일부만 돌려준다
+		whole := b.parts[0]
+		whole.tokens -= n
+		refund := &Reservation{ok: true, tokens: n, timeToAct: whole.timeToAct, lim: whole.lim}
+		refund.Cancel()
+		return
+	}
+	for ; n > 0 && len(b.parts) > 0; n-- {
+		last := b.parts[len(b.parts)-1]
+		b.parts = b.parts[:len(b.parts)-1]
+		last.Cancel()
+	}
+}
+
+// ReserveUpTo AllowUpTo와 같지만 다 쓰지 못한 토큰을 돌려줄 수 있다
+// batchReserver가 아니면 토큰마다 예약하고, 예약도 못 하는 리미터면 AllowUpTo처럼 가져가기만 한다
+func ReserveUpTo(rl RateLimiter, max int) *BatchReservation {
+	if br, ok := rl.(batchReserver); ok {
+		r := br.reserveUpTo(max)
+		if !r.OK() || r.tokens == 0 {
+			return &BatchReservation{}
+		}
+		return &BatchReservation{tokens: r.tokens, parts: []*Reservation{r}}
+	}
+	if res, ok := rl.(reserver); ok {
+		b := &BatchReservation{}
+		for b.tokens < max {
+			r := res.reserveN(1, 0)
+			if !r.OK() {
+				break
+			}
+			b.parts = append(b.parts, r)
+			b.tokens++
+		}
+		return b
+	}
+	return &BatchReservation{tokens: AllowUpTo(rl, max)}
+}
+
+// allowUpTo 바로 허용되는 만큼 나눠서 차감한다. 거절된 reserve는 상태를 바꾸지 않으므로
+// 큰 덩어리부터 시도하고 거절되면 반으로 줄인다. reserve 호출은 O(log² max)번
+func allowUpTo(alg algorithm, now time.Time, max int) int {
+	granted := 0
+	for step := max; step > 0 && granted < max; {
+		n := min(step, max-granted)
+		if _, ok := alg.reserve(now, n, 0); ok {
+			granted += n
+			continue
+		}
+		step /= 2
+	}
+	return granted
+}
+
+// algorithm 알고리즘별 상태 전이. 리미터 고루틴 안에서만 호출되므로 따로 락을 잡지 않는다
+type algorithm interface {
+	// reserve now 기준으로 tokens개를 쓸 수 있는 시각을 계산한다
+	// 그 시각이 now+maxWait 이내이면 토큰을 차감하고 ok=true, 아니면 상태를 바꾸지 않고 ok=false
+	// 용량을 넘는 요청처럼 영영 불가능한 경우에는 zero time을 돌려준다
+	reserve(now time.Time, tokens int, maxWait time.Duration) (timeToAct time.Time, ok bool)
+	// cancel reserve로 차감했던 토큰을 되돌린다
+	cancel(now, timeToAct time.Time, tokens int)
+}
+
+// limiterCore 알고리즘을 실행하는 방식
+// RateLimiterBase는 전담 고루틴과 채널로, SyncLimiterBase는 짧은 뮤텍스 구간으로 상태를 보호한다
+type limiterCore interface {
+	Reserver
+	RetryAfterLimiter
+	BatchLimiter
+	reserver
+	batchReserver
+	reservationCanceler
+	exec(fn func()) bool
+}
+
+type requestTokensCh struct {
+	tokens  int
+	maxWait time.Duration
+	resCh   chan reserveResult
+}
+
+type reserveResult struct {
+	timeToAct time.Time
+	ok        bool
 }
 
 type RateLimiterBase struct {
+	alg      algorithm
 	allowCh  chan requestTokensCh
+	execCh   chan func()
+	done     <-chan struct{}
 	stopFunc context.CancelFunc
 	wg       sync.WaitGroup
-	isClosed bool
-	mu       sync.RWMutex
+}
+
+// newRateLimiterBase alg를 전담 고루틴에서 돌린다. 모든 상태 변경은 이 고루틴에서만 일어난다
+func newRateLimiterBase(ctx context.Context, alg algorithm) *RateLimiterBase {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	rlb := &RateLimiterBase{
+		alg:      alg,
+		allowCh:  make(chan requestTokensCh, LIMITER_CAPACITY),
+		execCh:   make(chan func()),
+		done:     ctx.Done(),
+		stopFunc: cancelFunc,
+	}
+
+	rlb.wg.Add(1)
+	go rlb.run(ctx)
+
+	return rlb
+}
+
+func (rlb *RateLimiterBase) run(ctx context.Context) {
+	// runs the algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+	defer rlb.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reqTokensCh := <-rlb.allowCh:
+			timeToAct, ok := rlb.alg.reserve(time.Now(), reqTokensCh.tokens, reqTokensCh.maxWait)
+			reqTokensCh.resCh <- reserveResult{timeToAct: timeToAct, ok: ok}
+			close(reqTokensCh.resCh)
+		case fn := <-rlb.execCh:
+			fn()
+		}
+	}
+}
+
+// request 리미터 고루틴에 예약을 요청한다. 리미터가 멈췄으면 false
+func (rlb *RateLimiterBase) request(tokens int, maxWait time.Duration) (reserveResult, bool) {
+	reqTokensCh := requestTokensCh{
+		tokens:  tokens,
+		maxWait: maxWait,
+		resCh:   make(chan reserveResult, 1),
+	}
+
+	select {
+	case rlb.allowCh <- reqTokensCh:
+	case <-rlb.done:
+		return reserveResult{}, false
+	}
+
+	select {
+	case res := <-reqTokensCh.resCh:
+		return res, true
+	case <-rlb.done:
+		return reserveResult{}, false
+	}
+}
+
+// exec fn을 리미터 고루틴에서 실행한다. 리미터가 멈췄으면 false
+func (rlb *RateLimiterBase) exec(fn func()) bool {
+	select {
+	case rlb.execCh <- fn:
+		return true
+	case <-rlb.done:
+		return false
+	}
 }
 
 func (rlb *RateLimiterBase) Allow(tokens int) bool {
 	if tokens <= 0 {
 		return false
 	}
-	isClosed := false
-	rlb.mu.RLock()
-	isClosed = rlb.isClosed
-	rlb.mu.RUnlock()
-	if isClosed {
-		return false
+	res, ok := rlb.request(tokens, 0)
+	return ok && res.ok
+}
+
+// AllowRetry Allow와 같지만 거절한 경우 토큰이 생길 때까지 남은 시간도 돌려준다
+func (rlb *RateLimiterBase) AllowRetry(tokens int) (bool, time.Duration) {
+	if tokens <= 0 {
+		return false, 0
 	}
+	now := time.Now()
+	res, ok := rlb.request(tokens, 0)
+	if !ok {
+		return false, 0
+	}
+	return res.ok, retryAfter(now, res)
+}
 
-	reqTokensCh := requestTokensCh{
+// AllowUpTo 리미터 고루틴 안에서 한 번에 계산하므로 그 사이 다른 요청이 끼어들지 않는다
+func (rlb *RateLimiterBase) AllowUpTo(max int) int {
+	return rlb.reserveUpTo(max).tokens
+}
+
+// reserveUpTo 가져간 토큰은 모두 같은 시각(now)에 차감되므로 그 시각으로 돌려줄 수 있다
+func (rlb *RateLimiterBase) reserveUpTo(max int) *Reservation {
+	r := &Reservation{lim: rlb}
+	if max <= 0 {
+		return r
+	}
+	// exec는 고루틴에 넘기기만 하므로 계산이 끝날 때까지 기다린다
+	done := make(chan struct{})
+	if !rlb.exec(func() {
+		r.timeToAct = time.Now()
+		r.tokens = allowUpTo(rlb.alg, r.timeToAct, max)
+		close(done)
+	}) {
+		return r
+	}
+	<-done
+	r.ok = r.tokens > 0
+	return r
+}
+
+// Reserve tokens개를 예약한다. 돌려받은 Reservation의 Delay만큼 기다린 뒤 사용하면 된다
+func (rlb *RateLimiterBase) Reserve(tokens int) *Reservation {
+	return rlb.reserveN(tokens, InfDuration)
+}
+
+// Wait tokens개를 쓸 수 있을 때까지 기다린다. ctx가 먼저 끝나면 예약을 되돌리고 에러를 돌려준다
+func (rlb *RateLimiterBase) Wait(ctx context.Context, tokens int) error {
+	return wait(ctx, rlb, tokens)
+}
+
+// retryAfter 거절된 요청의 재시도까지 남은 시간. 허용됐거나 불가능한 요청이면 0
+func retryAfter(now time.Time, res reserveResult) time.Duration {
+	if res.ok || res.timeToAct.IsZero() {
+		return 0
+	}
+	return res.timeToAct.Sub(now)
+}
+
+func (rlb *RateLimiterBase) reserveN(tokens int, maxWait time.Duration) *Reservation {
+	r := &Reservation{
 		tokens: tokens,
-		resCh:  make(chan bool, 1),
+		lim:    rlb,
+	}
+	if tokens <= 0 {
+		return r
+	}
+
+	res, ok := rlb.request(tokens, maxWait)
+	if !ok {
+		return r
 	}
+	r.ok = res.ok
+	r.timeToAct = res.timeToAct
+	return r
+}
 
-	rlb.allowCh <- reqTokensCh
-	return <-reqTokensCh.resCh
+func (rlb *RateLimiterBase) cancelReservation(r *Reservation) {
+	rlb.exec(func() {
+		rlb.alg.cancel(time.Now(), r.timeToAct, r.tokens)
+	})
 }
 
 func (rlb *RateLimiterBase) Stop() {
 	rlb.stopFunc()
 	rlb.wg.Wait()
-	rlb.mu.Lock()
-	rlb.isClosed = true
-	rlb.mu.Unlock()
-	close(rlb.allowCh)
 }
 
 type TokenBucket struct {
@@ -62,101 +343,104 @@ type TokenBucket struct {
 	tokensPerSecond float32
 	tokens          float32
 	lastTime        time.Time
-	*RateLimiterBase
+	limiterCore
 }
 
 func NewTokenBucket(ctx context.Context, capacity, tokensPerSecond, tokens float32) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(ctx)
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
-	}
-	rl := &TokenBucket{
-		RateLimiterBase: rlBase,
+	rl := newTokenBucket(capacity, tokensPerSecond, tokens)
+	rl.limiterCore = newRateLimiterBase(ctx, rl)
+
+	return rl
+}
+
+// NewSyncTokenBucket 전담 고루틴 없이 뮤텍스로 동작하는 TokenBucket
+func NewSyncTokenBucket(capacity, tokensPerSecond, tokens float32) RateLimiter {
+	rl := newTokenBucket(capacity, tokensPerSecond, tokens)
+	rl.limiterCore = newSyncLimiterBase(rl)
+
+	return rl
+}
+
+func newTokenBucket(capacity, tokensPerSecond, tokens float32) *TokenBucket {
+	return &TokenBucket{
 		capacity:        capacity,
 		tokensPerSecond: tokensPerSecond,
 		tokens:          tokens,
 		lastTime:        time.Now(),
 	}
-	rl.refillTokens()
-	rl.wg.Add(1)
-	go rl.tokenBucketAlgorithm(ctx)
-
-	return rl
 }
 
-func (rl *TokenBucket) tokenBucketAlgorithm(ctx context.Context) {
-	// runs the token bucket algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
-	defer rl.wg.Done()
-
-	// tokenPerSecond를 적용한것
-	// ticker := time.NewTicker(time.Duration(1e9 / int64(rl.tokensPerSecond)))
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+func (rl *TokenBucket) reserve(now time.Time, tokens int, maxWait time.Duration) (time.Time, bool) {
+	requested := float32(tokens)
+	if requested > rl.capacity || rl.tokensPerSecond <= 0 {
+		return time.Time{}, false
+	}
+	rl.refillTokens(now)
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			rl.refillTokens()
-		case reqTokensCh := <-rl.allowCh:
-			fmt.Printf("available tokens: %f \n", rl.tokens)
+	var wait time.Duration
+	if requested > rl.tokens {
+		// 모자란 토큰이 채워질 때까지 기다린다. 그동안 tokens는 음수가 될 수 있다
+		wait = secondsToDuration(float64((requested - rl.tokens) / rl.tokensPerSecond))
+	}
+	timeToAct := now.Add(wait)
+	if wait > maxWait {
+		return timeToAct, false
+	}
 
-			resp := false
+	rl.tokens -= requested
+	return timeToAct, true
+}
 
-			if float32(reqTokensCh.tokens) <= rl.tokens {
-				rl.tokens -= float32(reqTokensCh.tokens)
-				resp = true
-			}
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
-		}
+func (rl *TokenBucket) cancel(now, timeToAct time.Time, tokens int) {
+	rl.refillTokens(now)
+	rl.tokens += float32(tokens)
+	if rl.tokens > rl.capacity {
+		rl.tokens = rl.capacity
 	}
 }
 
-func (rl *TokenBucket) refillTokens() {
-	// 이 방법은 tokenBucketAlgorithm에서 tokenPerSecond를 적용하지 않았을때
-	// 초당 1번 불러지면 tokenPerSecond만큼의 토큰을 한번에 충전
-	// tokenPerSecond가 tokenBucketAlgorithm에 적용 되었다면
-	// 예를 들어 초당 5개의 토큰이 생성된다 가정했을때
-	// 200ms마다 토큰을 하나씩 충전하는 형태가 된다
-
-	// currentTime := time.Now()
-	// timePassed := currentTime.Sub(rl.lastTime).Seconds()
-	// temp := rl.tokens + int(timePassed)*rl.tokensPerSecond
-	// rl.tokens = temp
-	// if rl.capacity < rl.tokens {
-	// 	rl.tokens = rl.capacity
-	// }
-	// rl.lastTime = currentTime
-
-	//=========================================================
-
-	newTokens := rl.tokensPerSecond
-	rl.tokens += newTokens
+func (rl *TokenBucket) refillTokens(now time.Time) {
+	// 티커로 초마다 채우지 않고 요청이 올 때 지난 시간만큼 한번에 충전한다
+	// 초 단위 아래도 그대로 반영되므로 tokensPerSecond가 0.1이든 100이든 똑같이 동작하고
+	// 버킷마다 고루틴을 따로 둘 필요가 없다
+	timePassed := now.Sub(rl.lastTime).Seconds()
+	if timePassed <= 0 {
+		return
+	}
 
+	rl.tokens += float32(timePassed) * rl.tokensPerSecond
 	if rl.tokens > rl.capacity {
 		rl.tokens = rl.capacity
 	}
+	rl.lastTime = now
+}
 
-	fmt.Printf("total tokens: %f \n", rl.tokens)
+// Config 현재 capacity, tokensPerSecond
+func (rl *TokenBucket) Config() (capacity, tokensPerSecond float32) {
+	done := make(chan struct{})
+	if rl.exec(func() {
+		capacity, tokensPerSecond = rl.capacity, rl.tokensPerSecond
+		close(done)
+	}) {
+		<-done
+	}
+	return capacity, tokensPerSecond
 }
 
 func (rl *TokenBucket) UpdateConfig(capacity, refillRate float32) error {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
 	if capacity <= 0 || refillRate <= 0 {
 		return fmt.Errorf("capacity / refillRate must be greater than 0")
 	}
-	if rl.capacity > capacity {
-		rl.tokens = capacity
-	}
 
-	rl.capacity = capacity
-	rl.tokensPerSecond = refillRate
+	rl.exec(func() {
+		// 바뀌기 전 속도로 지금까지 쌓인 토큰을 먼저 반영
+		rl.refillTokens(time.Now())
+		if rl.tokens > capacity {
+			rl.tokens = capacity
+		}
+		rl.capacity = capacity
+		rl.tokensPerSecond = refillRate
+	})
 
 	return nil
 }
@@ -164,199 +448,473 @@ func (rl *TokenBucket) UpdateConfig(capacity, refillRate float32) error {
 type LeakyBucket struct {
 	capacity int
 	leakRate int
-	tokens   int
+	tokens   float64 // 버킷에 차 있는 양. 초당 leakRate만큼 빠져나간다
 	lastTime time.Time
-	*RateLimiterBase
+	limiterCore
 }
 
 func NewLeakyBucket(capacity, leakRate int) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
-	}
-	rl := &LeakyBucket{
-		RateLimiterBase: rlBase,
-		capacity:        capacity,
-		leakRate:        leakRate,
-		tokens:          capacity,
-		lastTime:        time.Now(),
-	}
+	rl := newLeakyBucket(capacity, leakRate)
+	rl.limiterCore = newRateLimiterBase(context.Background(), rl)
+
+	return rl
+}
 
-	rl.wg.Add(1)
-	go rl.leakyBucketAlgorithm(ctx)
+// NewSyncLeakyBucket 전담 고루틴 없이 뮤텍스로 동작하는 LeakyBucket
+func NewSyncLeakyBucket(capacity, leakRate int) RateLimiter {
+	rl := newLeakyBucket(capacity, leakRate)
+	rl.limiterCore = newSyncLimiterBase(rl)
 
 	return rl
 }
 
-func (rl *LeakyBucket) leakyBucketAlgorithm(ctx context.Context) {
-	// runs the leaky bucket algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+func newLeakyBucket(capacity, leakRate int) *LeakyBucket {
+	return &LeakyBucket{
+		capacity: capacity,
+		leakRate: leakRate,
+		lastTime: time.Now(),
+	}
+}
 
-	defer rl.wg.Done()
+func (rl *LeakyBucket) leak(now time.Time) {
+	timePassed := now.Sub(rl.lastTime).Seconds()
+	rl.tokens -= timePassed * float64(rl.leakRate)
+	if rl.tokens < 0 {
+		rl.tokens = 0
+	}
+	rl.lastTime = now
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case reqTokensCh := <-rl.allowCh:
-			fmt.Printf("tokens requested: %d, available tokens: %d ", reqTokensCh.tokens, rl.tokens)
-			currentTime := time.Now()
-			timePassed := currentTime.Sub(rl.lastTime).Seconds()
-
-			leakedTokens := int(timePassed) * rl.leakRate
-
-			temp := rl.tokens - leakedTokens
-			if temp < 0 {
-				rl.tokens = 0
-			} else {
-				rl.tokens = temp
-			}
-			fmt.Printf("total new tokens: %d ", rl.tokens)
+func (rl *LeakyBucket) reserve(now time.Time, tokens int, maxWait time.Duration) (time.Time, bool) {
+	if tokens > rl.capacity || rl.leakRate <= 0 {
+		return time.Time{}, false
+	}
+	rl.leak(now)
 
-			rl.lastTime = currentTime
-			resp := false
+	var wait time.Duration
+	if overflow := rl.tokens + float64(tokens) - float64(rl.capacity); overflow > 0 {
+		// 넘치는 만큼 빠져나갈 때까지 기다린다
+		wait = secondsToDuration(overflow / float64(rl.leakRate))
+	}
+	timeToAct := now.Add(wait)
+	if wait > maxWait {
+		return timeToAct, false
+	}
 
-			if reqTokensCh.tokens <= (rl.capacity - rl.tokens) {
-				rl.tokens += reqTokensCh.tokens
-				resp = true
-			} else {
-				resp = false
-			}
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
-		}
+	rl.tokens += float64(tokens)
+	return timeToAct, true
+}
+
+func (rl *LeakyBucket) cancel(now, timeToAct time.Time, tokens int) {
+	rl.leak(now)
+	rl.tokens -= float64(tokens)
+	if rl.tokens < 0 {
+		rl.tokens = 0
 	}
 }
 
+func (rl *LeakyBucket) UpdateConfig(capacity, leakRate int) error {
+	if capacity <= 0 || leakRate <= 0 {
+		return fmt.Errorf("capacity / leakRate must be greater than 0")
+	}
+
+	rl.exec(func() {
+		// 바뀌기 전 속도로 지금까지 빠져나간 양을 먼저 반영
+		rl.leak(time.Now())
+		rl.capacity = capacity
+		rl.leakRate = leakRate
+	})
+
+	return nil
+}
+
 type FixedWindow struct {
-	tokens     int
+	tokens     int // 현재 윈도우에 남은 토큰. 다음 윈도우 몫을 미리 예약하면 음수가 된다
 	windowSize int
 	capacity   int
-	lastTime   time.Time
-	*RateLimiterBase
+	lastTime   time.Time // 현재 윈도우 시작 시각
+	limiterCore
 }
 
 func NewFixedWindow(windowSize, capacity int) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
-	}
-	rl := &FixedWindow{
-		RateLimiterBase: rlBase,
-		tokens:          capacity,
-		capacity:        capacity,
-		windowSize:      windowSize,
-		lastTime:        time.Now(),
-	}
+	rl := newFixedWindow(windowSize, capacity)
+	rl.limiterCore = newRateLimiterBase(context.Background(), rl)
 
-	rl.wg.Add(1)
-	go rl.fixedWindowAlgorithm(ctx)
+	return rl
+}
+
+// NewSyncFixedWindow 전담 고루틴 없이 뮤텍스로 동작하는 FixedWindow
+func NewSyncFixedWindow(windowSize, capacity int) RateLimiter {
+	rl := newFixedWindow(windowSize, capacity)
+	rl.limiterCore = newSyncLimiterBase(rl)
 
 	return rl
 }
 
-func (rl *FixedWindow) fixedWindowAlgorithm(ctx context.Context) {
-	// runs the fixed window algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+func newFixedWindow(windowSize, capacity int) *FixedWindow {
+	return &FixedWindow{
+		tokens:     capacity,
+		capacity:   capacity,
+		windowSize: windowSize,
+		lastTime:   time.Now(),
+	}
+}
 
-	defer rl.wg.Done()
+// advance now가 속한 윈도우로 이동하면서 지나간 윈도우 수만큼 토큰을 채운다
+func (rl *FixedWindow) advance(now time.Time) {
+	window := time.Duration(rl.windowSize) * time.Second
+	passed := int64(now.Sub(rl.lastTime) / window)
+	if passed <= 0 {
+		return
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case reqTokensCh := <-rl.allowCh:
-			fmt.Printf("tokens requested: %d, available tokens: %d ", reqTokensCh.tokens, rl.tokens)
-			currentTime := time.Now()
-			timePassed := int(currentTime.Sub(rl.lastTime).Seconds())
-
-			resp := false
-			if timePassed >= rl.windowSize {
-				rl.lastTime = currentTime
-				rl.tokens = rl.capacity - reqTokensCh.tokens
-				if rl.tokens < 0 {
-					rl.tokens = rl.capacity
-					resp = false
-				} else {
-					resp = true
-				}
-			} else {
-				if rl.tokens >= reqTokensCh.tokens {
-					rl.tokens -= reqTokensCh.tokens
-					resp = true
-				} else {
-					resp = false
-				}
-			}
-			fmt.Printf("total new tokens: %d ", rl.tokens)
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
-		}
+	rl.lastTime = rl.lastTime.Add(time.Duration(passed) * window)
+	if passed > int64(rl.capacity-rl.tokens)/int64(rl.capacity) {
+		rl.tokens = rl.capacity
+	} else {
+		rl.tokens = min(rl.capacity, rl.tokens+int(passed)*rl.capacity)
+	}
+}
+
+func (rl *FixedWindow) reserve(now time.Time, tokens int, maxWait time.Duration) (time.Time, bool) {
+	if tokens > rl.capacity || rl.windowSize <= 0 {
+		return time.Time{}, false
+	}
+	rl.advance(now)
+
+	timeToAct := now
+	if tokens > rl.tokens {
+		// 모자란 토큰이 채워지는 윈도우가 시작될 때까지 기다린다
+		windows := (tokens - rl.tokens + rl.capacity - 1) / rl.capacity
+		timeToAct = rl.lastTime.Add(time.Duration(windows*rl.windowSize) * time.Second)
 	}
+	if timeToAct.Sub(now) > maxWait {
+		return timeToAct, false
+	}
+
+	rl.tokens -= tokens
+	return timeToAct, true
+}
+
+func (rl *FixedWindow) cancel(now, timeToAct time.Time, tokens int) {
+	rl.advance(now)
+	rl.tokens = min(rl.capacity, rl.tokens+tokens)
+}
+
+func (rl *FixedWindow) UpdateConfig(windowSize, capacity int) error {
+	if windowSize <= 0 || capacity <= 0 {
+		return fmt.Errorf("windowSize / capacity must be greater than 0")
+	}
+
+	rl.exec(func() {
+		rl.advance(time.Now())
+		// 현재 윈도우에서 이미 쓴 양은 그대로 두고 용량 차이만큼 더하거나 뺀다
+		rl.tokens = min(capacity, rl.tokens+capacity-rl.capacity)
+		rl.capacity = capacity
+		rl.windowSize = windowSize
+	})
+
+	return nil
 }
 
 type SlidingWindow struct {
 	limit      int
 	windowSize time.Duration
-	timeStamps []time.Time
-	*RateLimiterBase
+	timeStamps []time.Time // 시간 순으로 정렬되어 있다. 예약된 미래 시각이 들어있을 수 있다
+	limiterCore
 }
 
 func NewSlidingWindow(limit int, windowSize time.Duration) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
+	rl := newSlidingWindow(limit, windowSize)
+	rl.limiterCore = newRateLimiterBase(context.Background(), rl)
+
+	return rl
+}
+
+// NewSyncSlidingWindow 전담 고루틴 없이 뮤텍스로 동작하는 SlidingWindow
+func NewSyncSlidingWindow(limit int, windowSize time.Duration) RateLimiter {
+	rl := newSlidingWindow(limit, windowSize)
+	rl.limiterCore = newSyncLimiterBase(rl)
+
+	return rl
+}
+
+func newSlidingWindow(limit int, windowSize time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		limit:      limit,
+		windowSize: windowSize,
+		timeStamps: make([]time.Time, 0),
 	}
-	rl := &SlidingWindow{
-		RateLimiterBase: rlBase,
-		limit:           limit,
-		windowSize:      windowSize,
-		timeStamps:      make([]time.Time, 0),
+}
+
+func (rl *SlidingWindow) reserve(now time.Time, tokens int, maxWait time.Duration) (time.Time, bool) {
+	if tokens > rl.limit {
+		return time.Time{}, false
+	}
+
+	// 윈도우를 벗어난 기록 제거
+	for len(rl.timeStamps) > 0 && !rl.timeStamps[0].After(now.Add(-rl.windowSize)) {
+		rl.timeStamps = rl.timeStamps[1:]
+	}
+
+	timeToAct := now
+	if over := len(rl.timeStamps) + tokens - rl.limit; over > 0 {
+		// 앞에서부터 over개의 기록이 윈도우를 벗어날 때까지 기다린다
+		timeToAct = rl.timeStamps[over-1].Add(rl.windowSize)
+	}
+	// 먼저 예약된 요청보다 앞설 수 없다
+	if n := len(rl.timeStamps); n > 0 && timeToAct.Before(rl.timeStamps[n-1]) {
+		timeToAct = rl.timeStamps[n-1]
+	}
+	if timeToAct.Sub(now) > maxWait {
+		return timeToAct, false
 	}
 
-	rl.wg.Add(1)
-	go rl.slidingWindowAlgorithm(ctx)
+	// append as many entries as tokens requested
+	for i := 0; i < tokens; i++ {
+		rl.timeStamps = append(rl.timeStamps, timeToAct)
+	}
+	return timeToAct, true
+}
+
+func (rl *SlidingWindow) cancel(now, timeToAct time.Time, tokens int) {
+	// 정렬되어 있으므로 뒤에서부터 timeToAct와 같은 기록을 찾아 지운다
+	end := len(rl.timeStamps)
+	for end > 0 && rl.timeStamps[end-1].After(timeToAct) {
+		end--
+	}
+	start := end
+	for start > 0 && end-start < tokens && rl.timeStamps[start-1].Equal(timeToAct) {
+		start--
+	}
+	rl.timeStamps = append(rl.timeStamps[:start], rl.timeStamps[end:]...)
+}
+
+func (rl *SlidingWindow) UpdateConfig(limit int, windowSize time.Duration) error {
+	if limit <= 0 || windowSize <= 0 {
+		return fmt.Errorf("limit / windowSize must be greater than 0")
+	}
+
+	rl.exec(func() {
+		rl.limit = limit
+		rl.windowSize = windowSize
+	})
+
+	return nil
+}
+
+// SlidingWindowCounter 직전 고정 윈도우의 요청 수를 현재 윈도우와 겹치는 비율만큼 반영해서
+// 슬라이딩 윈도우를 근사한다. 요청마다 기록을 남기지 않으므로 메모리가 일정하다
+type SlidingWindowCounter struct {
+	limit       int
+	windowSize  time.Duration
+	windowStart time.Time // 현재 윈도우 시작 시각
+	prevCount   int       // 직전 윈도우 요청 수
+	currCount   int       // 현재 윈도우 요청 수
+	nextCount   int       // 다음 윈도우로 예약된 요청 수
+	limiterCore
+}
+
+func NewSlidingWindowCounter(limit int, windowSize time.Duration) RateLimiter {
+	rl := newSlidingWindowCounter(limit, windowSize)
+	rl.limiterCore = newRateLimiterBase(context.Background(), rl)
 
 	return rl
 }
 
-func (rl *SlidingWindow) slidingWindowAlgorithm(ctx context.Context) {
-	// runs the sliding window algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+// NewSyncSlidingWindowCounter 전담 고루틴 없이 뮤텍스로 동작하는 SlidingWindowCounter
+func NewSyncSlidingWindowCounter(limit int, windowSize time.Duration) RateLimiter {
+	rl := newSlidingWindowCounter(limit, windowSize)
+	rl.limiterCore = newSyncLimiterBase(rl)
 
-	defer rl.wg.Done()
+	return rl
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case reqTokensCh := <-rl.allowCh:
-			currentTime := time.Now()
-			// append as many entries as tokens requested
-			for i := 0; i < reqTokensCh.tokens; i++ {
-				rl.timeStamps = append(rl.timeStamps, currentTime)
-			}
-			fmt.Printf("total requests: %d, limit: %d ", reqTokensCh.tokens, rl.limit)
+func newSlidingWindowCounter(limit int, windowSize time.Duration) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		limit:       limit,
+		windowSize:  windowSize,
+		windowStart: time.Now(),
+	}
+}
 
-			for len(rl.timeStamps) > 0 && rl.timeStamps[0].Before(currentTime.Add(-rl.windowSize)) {
-				rl.timeStamps = rl.timeStamps[1:]
-			}
-			fmt.Printf("total requests after sliding: %d ", len(rl.timeStamps))
-			resp := false
-			totalTokensInWindow := len(rl.timeStamps)
-			if totalTokensInWindow <= rl.limit {
-				resp = true
-			} else {
-				// roll back the tokens if the request can't be fulfilled
-				rl.timeStamps = rl.timeStamps[:totalTokensInWindow-reqTokensCh.tokens]
-			}
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
+// advance now가 속한 윈도우로 카운터를 밀어낸다
+func (rl *SlidingWindowCounter) advance(now time.Time) {
+	passed := int64(now.Sub(rl.windowStart) / rl.windowSize)
+	if passed <= 0 {
+		return
+	}
+
+	switch passed {
+	case 1:
+		rl.prevCount, rl.currCount, rl.nextCount = rl.currCount, rl.nextCount, 0
+	case 2:
+		rl.prevCount, rl.currCount, rl.nextCount = rl.nextCount, 0, 0
+	default:
+		rl.prevCount, rl.currCount, rl.nextCount = 0, 0, 0
+	}
+	rl.windowStart = rl.windowStart.Add(time.Duration(passed) * rl.windowSize)
+}
+
+// earliest start에서 시작하는 윈도우 안에서 직전 윈도우 몫(prev * 남은 비율)이 room 이하로 줄어드는 가장 이른 시각
+// from보다 앞서지 않으며, 윈도우 안에서 불가능하면 zero time
+func (rl *SlidingWindowCounter) earliest(start, from time.Time, prev, room int) time.Time {
+	if prev <= room {
+		return from
+	}
+
+	elapsed := time.Duration(math.Ceil(float64(rl.windowSize) * float64(prev-room) / float64(prev)))
+	if elapsed >= rl.windowSize {
+		return time.Time{}
+	}
+	if t := start.Add(elapsed); t.After(from) {
+		return t
+	}
+	return from
+}
+
+func (rl *SlidingWindowCounter) reserve(now time.Time, tokens int, maxWait time.Duration) (time.Time, bool) {
+	if tokens > rl.limit || rl.windowSize <= 0 {
+		return time.Time{}, false
+	}
+	rl.advance(now)
+
+	free := rl.limit - tokens
+	var timeToAct time.Time
+	// 다음 윈도우에 예약된 요청이 있으면 그보다 앞서지 않는다
+	if rl.nextCount == 0 && rl.currCount <= free {
+		timeToAct = rl.earliest(rl.windowStart, now, rl.prevCount, free-rl.currCount)
+	}
+
+	inNext := false
+	if timeToAct.IsZero() {
+		if rl.nextCount > free {
+			return time.Time{}, false
+		}
+		nextStart := rl.windowStart.Add(rl.windowSize)
+		timeToAct = rl.earliest(nextStart, nextStart, rl.currCount, free-rl.nextCount)
+		if timeToAct.IsZero() {
+			return time.Time{}, false
 		}
+		inNext = true
+	}
+	if timeToAct.Sub(now) > maxWait {
+		return timeToAct, false
+	}
+
+	if inNext {
+		rl.nextCount += tokens
+	} else {
+		rl.currCount += tokens
+	}
+	return timeToAct, true
+}
+
+func (rl *SlidingWindowCounter) cancel(now, timeToAct time.Time, tokens int) {
+	rl.advance(now)
+
+	var count *int
+	switch {
+	case !timeToAct.Before(rl.windowStart.Add(rl.windowSize)):
+		count = &rl.nextCount
+	case !timeToAct.Before(rl.windowStart):
+		count = &rl.currCount
+	case !timeToAct.Before(rl.windowStart.Add(-rl.windowSize)):
+		count = &rl.prevCount
+	default:
+		return
 	}
+	*count = max(0, *count-tokens)
+}
+
+func (rl *SlidingWindowCounter) UpdateConfig(limit int, windowSize time.Duration) error {
+	if limit <= 0 || windowSize <= 0 {
+		return fmt.Errorf("limit / windowSize must be greater than 0")
+	}
+
+	rl.exec(func() {
+		rl.advance(time.Now())
+		rl.limit = limit
+		rl.windowSize = windowSize
+	})
+
+	return nil
+}
+
+// GCRA generic cell rate algorithm
+// 키마다 "이론상 다음 요청 도착 시각(tat)" 하나만 저장하고, 거절할 때 정확한 재시도 시각을 계산할 수 있다
+type GCRA struct {
+	emissionInterval time.Duration // 토큰 하나가 채워지는 간격 (1 / 초당 요청 수)
+	burst            int           // 한 번에 몰아서 허용할 수 있는 요청 수
+	tat              time.Time
+	limiterCore
+}
+
+func NewGCRA(ctx context.Context, ratePerSecond float32, burst int) RateLimiter {
+	rl := newGCRA(ratePerSecond, burst)
+	rl.limiterCore = newRateLimiterBase(ctx, rl)
+
+	return rl
+}
+
+// NewSyncGCRA 전담 고루틴 없이 뮤텍스로 동작하는 GCRA
+func NewSyncGCRA(ratePerSecond float32, burst int) RateLimiter {
+	rl := newGCRA(ratePerSecond, burst)
+	rl.limiterCore = newSyncLimiterBase(rl)
+
+	return rl
+}
+
+func newGCRA(ratePerSecond float32, burst int) *GCRA {
+	var interval time.Duration
+	if ratePerSecond > 0 {
+		interval = secondsToDuration(1 / float64(ratePerSecond))
+	}
+	return &GCRA{
+		emissionInterval: interval,
+		burst:            burst,
+	}
+}
+
+func (rl *GCRA) reserve(now time.Time, tokens int, maxWait time.Duration) (time.Time, bool) {
+	if tokens > rl.burst || rl.emissionInterval <= 0 {
+		return time.Time{}, false
+	}
+
+	tat := rl.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(time.Duration(tokens) * rl.emissionInterval)
+
+	// newTat이 now + burst 간격 안에 들어오는 시점부터 허용
+	timeToAct := newTat.Add(-time.Duration(rl.burst) * rl.emissionInterval)
+	if timeToAct.Before(now) {
+		timeToAct = now
+	}
+	if timeToAct.Sub(now) > maxWait {
+		return timeToAct, false
+	}
+
+	rl.tat = newTat
+	return timeToAct, true
+}
+
+func (rl *GCRA) cancel(now, timeToAct time.Time, tokens int) {
+	rl.tat = rl.tat.Add(-time.Duration(tokens) * rl.emissionInterval)
+}
+
+func (rl *GCRA) UpdateConfig(ratePerSecond float32, burst int) error {
+	if ratePerSecond <= 0 || burst <= 0 {
+		return fmt.Errorf("rate / burst must be greater than 0")
+	}
+
+	rl.exec(func() {
+		rl.emissionInterval = secondsToDuration(1 / float64(ratePerSecond))
+		rl.burst = burst
+	})
+
+	return nil
 }
 
 func main() {
@@ -0,0 +1,91 @@
+package limiters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchmarkAllow 동시에 Allow를 부르는 고루틴 수를 1, 8, 64로 바꿔가며 측정한다
+func benchmarkAllow(b *testing.B, newLimiter func() RateLimiter) {
+	for _, callers := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("callers=%d", callers), func(b *testing.B) {
+			rl := newLimiter()
+			defer rl.Stop()
+
+			var wg sync.WaitGroup
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < callers; i++ {
+				n := b.N / callers
+				if i < b.N%callers {
+					n++
+				}
+				wg.Add(1)
+				go func(n int) {
+					defer wg.Done()
+					for j := 0; j < n; j++ {
+						rl.Allow(1)
+					}
+				}(n)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkTokenBucket(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewTokenBucket(context.Background(), 1000, 1000, 1000) })
+	})
+	b.Run("sync", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSyncTokenBucket(1000, 1000, 1000) })
+	})
+}
+
+func BenchmarkLeakyBucket(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewLeakyBucket(1000, 1000) })
+	})
+	b.Run("sync", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSyncLeakyBucket(1000, 1000) })
+	})
+}
+
+func BenchmarkFixedWindow(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewFixedWindow(1, 1000) })
+	})
+	b.Run("sync", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSyncFixedWindow(1, 1000) })
+	})
+}
+
+func BenchmarkSlidingWindow(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSlidingWindow(1000, time.Second) })
+	})
+	b.Run("sync", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSyncSlidingWindow(1000, time.Second) })
+	})
+}
+
+func BenchmarkSlidingWindowCounter(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSlidingWindowCounter(1000, time.Second) })
+	})
+	b.Run("sync", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSyncSlidingWindowCounter(1000, time.Second) })
+	})
+}
+
+func BenchmarkGCRA(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewGCRA(context.Background(), 1000, 1000) })
+	})
+	b.Run("sync", func(b *testing.B) {
+		benchmarkAllow(b, func() RateLimiter { return NewSyncGCRA(1000, 1000) })
+	})
+}
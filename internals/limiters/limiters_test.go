@@ -0,0 +1,133 @@
+package limiters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 대기열 워커가 토큰을 가져간 뒤 꺼낸 인원이 적으면 남은 토큰을 돌려준다
+func TestReserveUpToRefund(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		new  func() RateLimiter
+	}{
+		{"tokenbucket", func() RateLimiter { return NewSyncTokenBucket(5, 0.001, 5) }},
+		{"slidingwindow", func() RateLimiter { return NewSyncSlidingWindow(5, time.Hour) }},
+		{"gcra", func() RateLimiter { return NewSyncGCRA(0.001, 5) }},
+		// 채널 기반 리미터. 리미터 고루틴이 계산을 끝내기 전에 결과를 읽지 않는지 -race로 확인한다
+		{"tokenbucket/channel", func() RateLimiter { return NewTokenBucket(context.Background(), 5, 0.001, 5) }},
+		{"slidingwindow/channel", func() RateLimiter { return NewSlidingWindow(5, time.Hour) }},
+		{"gcra/channel", func() RateLimiter { return NewGCRA(context.Background(), 0.001, 5) }},
+		{"composite", func() RateLimiter {
+			cl, err := NewCompositeLimiter(NewSyncTokenBucket(5, 0.001, 5), NewSyncFixedWindow(3600, 100))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return cl
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rl := tc.new()
+			defer rl.Stop()
+
+			grant := ReserveUpTo(rl, 10)
+			if grant.Tokens() != 5 {
+				t.Fatalf("got %d tokens, want 5", grant.Tokens())
+			}
+			grant.Refund(3)
+			if grant.Tokens() != 2 {
+				t.Fatalf("%d tokens left after refund, want 2", grant.Tokens())
+			}
+
+			if got := AllowUpTo(rl, 10); got != 3 {
+				t.Fatalf("got %d tokens after refunding 3, want 3", got)
+			}
+		})
+	}
+}
+
+// 알고리즘을 고정된 시각으로 직접 불러서 경계 값을 정확히 확인한다
+func TestSlidingWindowCounterWeightsPreviousWindow(t *testing.T) {
+	t0 := time.Now()
+	rl := newSlidingWindowCounter(10, time.Second)
+	rl.windowStart = t0
+
+	if _, ok := rl.reserve(t0, 10, 0); !ok {
+		t.Fatal("first window: 10 of 10 rejected")
+	}
+	// 윈도우가 막 넘어가면 직전 윈도우 10개가 그대로 남아있다
+	if _, ok := rl.reserve(t0.Add(time.Second), 1, 0); ok {
+		t.Fatal("allowed right after rollover while the previous window was full")
+	}
+	// 절반 지나면 직전 윈도우 몫은 10 * 0.5 = 5
+	timeToAct, ok := rl.reserve(t0.Add(1500*time.Millisecond), 6, 0)
+	if ok {
+		t.Fatal("6 allowed with 5 still counted from the previous window")
+	}
+	// 6개가 들어가려면 직전 윈도우 몫이 4가 되어야 한다: 1s * (10-4)/10 = 600ms
+	if want := t0.Add(1600 * time.Millisecond); !timeToAct.Equal(want) {
+		t.Fatalf("retry at %v, want %v", timeToAct.Sub(t0), want.Sub(t0))
+	}
+	if _, ok := rl.reserve(t0.Add(1500*time.Millisecond), 5, 0); !ok {
+		t.Fatal("5 rejected with 5 counted from the previous window")
+	}
+}
+
+func TestSlidingWindowCounterLimits(t *testing.T) {
+	t0 := time.Now()
+	rl := newSlidingWindowCounter(10, time.Second)
+	rl.windowStart = t0
+
+	// limit보다 큰 요청은 기다려도 들어갈 수 없으므로 재시도 시각이 없다
+	if timeToAct, ok := rl.reserve(t0, 11, InfDuration); ok || !timeToAct.IsZero() {
+		t.Fatalf("request over the limit: got %v ok %v, want zero time", timeToAct, ok)
+	}
+
+	timeToAct, ok := rl.reserve(t0, 10, 0)
+	if !ok {
+		t.Fatal("10 of 10 rejected")
+	}
+	rl.cancel(t0, timeToAct, 10)
+	if _, ok := rl.reserve(t0, 10, 0); !ok {
+		t.Fatal("cancel did not restore capacity")
+	}
+}
+
+func TestGCRARetryAfter(t *testing.T) {
+	t0 := time.Now()
+	rl := newGCRA(10, 5) // 100ms마다 하나, 5개까지 몰아서
+
+	if _, ok := rl.reserve(t0, 5, 0); !ok {
+		t.Fatal("burst of 5 rejected")
+	}
+	timeToAct, ok := rl.reserve(t0, 1, 0)
+	if ok {
+		t.Fatal("allowed past the burst")
+	}
+	if want := t0.Add(100 * time.Millisecond); !timeToAct.Equal(want) {
+		t.Fatalf("retry at %v, want %v", timeToAct.Sub(t0), want.Sub(t0))
+	}
+	if _, ok := rl.reserve(timeToAct, 1, 0); !ok {
+		t.Fatal("rejected at the reported retry time")
+	}
+}
+
+func TestGCRALimits(t *testing.T) {
+	t0 := time.Now()
+	rl := newGCRA(10, 5)
+
+	// burst보다 큰 요청은 기다려도 들어갈 수 없으므로 재시도 시각이 없다
+	if timeToAct, ok := rl.reserve(t0, 6, InfDuration); ok || !timeToAct.IsZero() {
+		t.Fatalf("request over the burst: got %v ok %v, want zero time", timeToAct, ok)
+	}
+
+	timeToAct, ok := rl.reserve(t0, 5, 0)
+	if !ok {
+		t.Fatal("burst of 5 rejected")
+	}
+	rl.cancel(t0, timeToAct, 5)
+	if _, ok := rl.reserve(t0, 5, 0); !ok {
+		t.Fatal("cancel did not restore capacity")
+	}
+}
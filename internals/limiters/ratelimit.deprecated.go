@@ -82,6 +82,7 @@ func (rl *RateLimiterWithQueue) ProcessRequest(ctx context.Context, userID strin
 }
 
 // checkAndUpdateTokens checks and updates token bucket
+// Deprecated: 읽기-수정-쓰기가 원자적이지 않다. RedisTokenBucket을 사용할 것
 func (rl *RateLimiterWithQueue) checkAndUpdateTokens(ctx context.Context, userID string) (float64, error) {
 	key := fmt.Sprintf("%s:tokens:%s", rl.keyPrefix, userID)
 	now := time.Now()
@@ -0,0 +1,341 @@
+package limiters
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 버킷 설정(capacity, tokensPerSecond)은 버킷 상태와 다른 키(<key>:config 해시의 capacity, rate 필드)에 둔다
+// 상태 키는 버킷이 가득 차면 만료되지만 설정 키는 만료시키지 않으므로, 한참 안 쓰인 뒤에도 바꾼 설정이 남아있다
+// UpdateConfig가 필드를 쓰고 스크립트는 필드가 있으면 인자 대신 그 값을 쓴다. 필드가 없으면 요청한 인스턴스의 값을 쓴다
+// 스크립트는 실제로 쓴 설정을 돌려주고 인스턴스는 그 값을 받아들이므로, 한 레플리카에서 바꾼 설정(설정 API, 적응형)이
+// 다른 레플리카에도 적용된다
+
+// tokenBucketScript 토큰 리필과 차감을 레디스 안에서 한 번에 처리한다
+// 시간은 레디스 서버 시간(TIME)을 사용해서 레플리카끼리 시계가 달라도 같은 버킷을 보게 한다
+//
+// KEYS[1] 버킷 상태 해시 키 (tokens, ts 필드), KEYS[2] 버킷 설정 해시 키 (capacity, rate 필드)
+// ARGV[1] capacity, ARGV[2] tokensPerSecond, ARGV[3] 요청 토큰 수, ARGV[4] 최대 대기 시간(ms)
+//
+// 반환값 {허용 여부(1/0), 토큰이 채워질 때까지 대기 시간(ms) 요청이 용량을 넘으면 -1, capacity, tokensPerSecond}
+var tokenBucketScript = redis.NewScript(`
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local conf = redis.call('HMGET', KEYS[2], 'capacity', 'rate')
+local capacity = tonumber(conf[1]) or tonumber(ARGV[1])
+local rate = tonumber(conf[2]) or tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local max_wait = tonumber(ARGV[4])
+
+if requested > capacity then
+	return {0, -1, tostring(capacity), tostring(rate)}
+end
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+local elapsed = math.max(0, now - ts)
+tokens = math.min(capacity, tokens + elapsed * rate / 1000)
+
+local wait = 0
+if tokens < requested then
+	wait = math.ceil((requested - tokens) * 1000 / rate)
+end
+if wait > max_wait then
+	return {0, wait, tostring(capacity), tostring(rate)}
+end
+
+tokens = tokens - requested
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+-- 버킷이 다시 가득 차면 키가 없는 것과 같으므로 그때 만료시킨다
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) * 1000 / rate) + 1000)
+return {1, wait, tostring(capacity), tostring(rate)}
+`)
+
+// refundScript 예약을 취소할 때 토큰을 돌려준다
+// KEYS[1] 버킷 상태 키, KEYS[2] 버킷 설정 키, ARGV[1] capacity, ARGV[2] tokensPerSecond, ARGV[3] 돌려줄 토큰 수
+var refundScript = redis.NewScript(`
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local conf = redis.call('HMGET', KEYS[2], 'capacity', 'rate')
+local capacity = tonumber(conf[1]) or tonumber(ARGV[1])
+local rate = tonumber(conf[2]) or tonumber(ARGV[2])
+local refund = tonumber(ARGV[3])
+
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	-- 토큰이 없으면 버킷이 가득 찬 상태
+	return 0
+end
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local elapsed = math.max(0, now - ts)
+tokens = math.min(capacity, tokens + elapsed * rate / 1000 + refund)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) * 1000 / rate) + 1000)
+return 1
+`)
+
+// allowUpToScript 지금 있는 토큰을 ARGV[3]개까지 차감한다
+// KEYS[1] 버킷 상태 키, KEYS[2] 버킷 설정 키, ARGV[1] capacity, ARGV[2] tokensPerSecond, ARGV[3] 최대 토큰 수
+//
+// 반환값 {차감한 토큰 수, capacity, tokensPerSecond}
+var allowUpToScript = redis.NewScript(`
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local conf = redis.call('HMGET', KEYS[2], 'capacity', 'rate')
+local capacity = tonumber(conf[1]) or tonumber(ARGV[1])
+local rate = tonumber(conf[2]) or tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+local elapsed = math.max(0, now - ts)
+tokens = math.min(capacity, tokens + elapsed * rate / 1000)
+
+local granted = math.max(0, math.min(max, math.floor(tokens)))
+tokens = tokens - granted
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) * 1000 / rate) + 1000)
+return {granted, tostring(capacity), tostring(rate)}
+`)
+
+// configureScript 버킷 설정을 바꾼다. 남은 토큰은 다음 요청 때 새 capacity로 잘린다
+// 설정 키는 만료시키지 않는다. 클라이언트별 버킷처럼 키가 많으면 설정을 바꾼 키 수만큼 남는다
+// KEYS[1] 버킷 설정 해시 키, ARGV[1] capacity, ARGV[2] tokensPerSecond
+var configureScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], 'capacity', ARGV[1], 'rate', ARGV[2])
+return 1
+`)
+
+// RedisTokenBucket 버킷 상태를 레디스에 저장하는 토큰 버킷
+// 같은 key를 쓰는 모든 서버 인스턴스가 하나의 버킷과 설정을 공유한다
+// capacity, tokensPerSecond는 레디스에서 마지막으로 본 설정이다
+type RedisTokenBucket struct {
+	rdb             *redis.Client
+	key             string
+	capacity        float32
+	tokensPerSecond float32
+	ctx             context.Context
+	stopFunc        context.CancelFunc
+	mu              sync.RWMutex
+}
+
+func NewRedisTokenBucket(ctx context.Context, rdb *redis.Client, key string, capacity, tokensPerSecond float32) RateLimiter {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	return &RedisTokenBucket{
+		rdb:             rdb,
+		key:             key,
+		capacity:        capacity,
+		tokensPerSecond: tokensPerSecond,
+		ctx:             ctx,
+		stopFunc:        cancelFunc,
+	}
+}
+
+func (rl *RedisTokenBucket) Allow(tokens int) bool {
+	if tokens <= 0 {
+		return false
+	}
+	if rl.ctx.Err() != nil {
+		return false
+	}
+
+	ok, _, err := rl.take(tokens, 0)
+	if err != nil {
+		// 레디스 장애 시에는 요청을 막는다 (fail closed)
+		log.Printf("redis token bucket %s: %v", rl.key, err)
+		return false
+	}
+	return ok
+}
+
+// AllowRetry Allow와 같지만 거절한 경우 토큰이 채워질 때까지 남은 시간도 돌려준다
+func (rl *RedisTokenBucket) AllowRetry(tokens int) (bool, time.Duration) {
+	if tokens <= 0 || rl.ctx.Err() != nil {
+		return false, 0
+	}
+
+	ok, waitMs, err := rl.take(tokens, 0)
+	if err != nil {
+		log.Printf("redis token bucket %s: %v", rl.key, err)
+		return false, 0
+	}
+	if ok || waitMs < 0 {
+		return ok, 0
+	}
+	return false, time.Duration(waitMs) * time.Millisecond
+}
+
+// AllowUpTo 레디스 왕복 한 번으로 가져간다
+func (rl *RedisTokenBucket) AllowUpTo(max int) int {
+	return rl.reserveUpTo(max).tokens
+}
+
+// reserveUpTo 취소하면 refundScript로 돌려준다
+func (rl *RedisTokenBucket) reserveUpTo(max int) *Reservation {
+	r := &Reservation{lim: rl, timeToAct: time.Now()}
+	r.tokens = rl.allowUpTo(max)
+	r.ok = r.tokens > 0
+	return r
+}
+
+func (rl *RedisTokenBucket) allowUpTo(max int) int {
+	if max <= 0 || rl.ctx.Err() != nil {
+		return 0
+	}
+
+	rl.mu.RLock()
+	capacity, rate := rl.capacity, rl.tokensPerSecond
+	rl.mu.RUnlock()
+
+	res, err := allowUpToScript.Run(rl.ctx, rl.rdb, rl.keys(), capacity, rate, max).Slice()
+	if err == nil {
+		err = rl.adoptConfig(res, 1)
+	}
+	if err != nil {
+		log.Printf("redis token bucket %s: %v", rl.key, err)
+		return 0
+	}
+	granted, _ := res[0].(int64)
+	return int(granted)
+}
+
+// take 스크립트를 실행해 토큰을 차감한다. maxWaitMs 안에 채워질 수 없으면 차감하지 않는다
+func (rl *RedisTokenBucket) take(tokens int, maxWaitMs int64) (bool, int64, error) {
+	rl.mu.RLock()
+	capacity, rate := rl.capacity, rl.tokensPerSecond
+	rl.mu.RUnlock()
+
+	res, err := tokenBucketScript.Run(rl.ctx, rl.rdb, rl.keys(), capacity, rate, tokens, maxWaitMs).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if err := rl.adoptConfig(res, 2); err != nil {
+		return false, 0, err
+	}
+	ok, _ := res[0].(int64)
+	waitMs, _ := res[1].(int64)
+	return ok == 1, waitMs, nil
+}
+
+// keys 스크립트에 넘기는 상태 키와 설정 키
+func (rl *RedisTokenBucket) keys() []string {
+	return []string{rl.key, rl.configKey()}
+}
+
+func (rl *RedisTokenBucket) configKey() string {
+	return rl.key + ":config"
+}
+
+// adoptConfig 스크립트가 돌려준 설정(res[i]는 capacity, res[i+1]은 tokensPerSecond)을 이 인스턴스의 설정으로 삼는다
+func (rl *RedisTokenBucket) adoptConfig(res []interface{}, i int) error {
+	if len(res) != i+2 {
+		return fmt.Errorf("unexpected script result: %v", res)
+	}
+	capStr, _ := res[i].(string)
+	rateStr, _ := res[i+1].(string)
+	capacity, err1 := strconv.ParseFloat(capStr, 32)
+	rate, err2 := strconv.ParseFloat(rateStr, 32)
+	if err1 != nil || err2 != nil || capacity <= 0 || rate <= 0 {
+		return fmt.Errorf("unexpected bucket config in script result: %v", res)
+	}
+
+	rl.mu.Lock()
+	rl.capacity = float32(capacity)
+	rl.tokensPerSecond = float32(rate)
+	rl.mu.Unlock()
+	return nil
+}
+
+// Reserve tokens개를 예약한다. 토큰이 모자라면 레디스의 버킷은 음수가 되고 Delay만큼 기다려야 한다
+func (rl *RedisTokenBucket) Reserve(tokens int) *Reservation {
+	return rl.reserveN(tokens, InfDuration)
+}
+
+// Wait tokens개를 쓸 수 있을 때까지 기다린다
+func (rl *RedisTokenBucket) Wait(ctx context.Context, tokens int) error {
+	return wait(ctx, rl, tokens)
+}
+
+func (rl *RedisTokenBucket) reserveN(tokens int, maxWait time.Duration) *Reservation {
+	r := &Reservation{
+		tokens: tokens,
+		lim:    rl,
+	}
+	if tokens <= 0 || rl.ctx.Err() != nil {
+		return r
+	}
+
+	now := time.Now()
+	ok, waitMs, err := rl.take(tokens, maxWait.Milliseconds())
+	if err != nil {
+		log.Printf("redis token bucket %s: %v", rl.key, err)
+		return r
+	}
+	if waitMs >= 0 {
+		r.timeToAct = now.Add(time.Duration(waitMs) * time.Millisecond)
+	}
+	r.ok = ok
+	return r
+}
+
+func (rl *RedisTokenBucket) cancelReservation(r *Reservation) {
+	rl.mu.RLock()
+	capacity, rate := rl.capacity, rl.tokensPerSecond
+	rl.mu.RUnlock()
+
+	if err := refundScript.Run(rl.ctx, rl.rdb, rl.keys(), capacity, rate, r.tokens).Err(); err != nil {
+		log.Printf("redis token bucket %s: refund failed: %v", rl.key, err)
+	}
+}
+
+func (rl *RedisTokenBucket) Stop() {
+	rl.stopFunc()
+}
+
+// Config 현재 capacity, tokensPerSecond. 다른 레플리카가 바꾼 설정은 이 인스턴스가 버킷을 한 번 쓴 뒤에 보인다
+func (rl *RedisTokenBucket) Config() (capacity, tokensPerSecond float32) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.capacity, rl.tokensPerSecond
+}
+
+func (rl *RedisTokenBucket) UpdateConfig(capacity, refillRate float32) error {
+	if capacity <= 0 || refillRate <= 0 {
+		return fmt.Errorf("capacity / refillRate must be greater than 0")
+	}
+
+	// 버킷을 공유하는 모든 레플리카가 같은 설정을 쓰도록 레디스에 쓴다
+	if err := configureScript.Run(rl.ctx, rl.rdb, []string{rl.configKey()}, capacity, refillRate).Err(); err != nil {
+		return fmt.Errorf("redis token bucket %s: %w", rl.key, err)
+	}
+
+	rl.mu.Lock()
+	rl.capacity = capacity
+	rl.tokensPerSecond = refillRate
+	rl.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package limiters
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// InfDuration 대기 시간 제한 없음
+const InfDuration = time.Duration(math.MaxInt64)
+
+// Reservation Reserve로 예약한 토큰. Delay만큼 기다린 뒤 사용하거나 Cancel로 되돌린다
+type Reservation struct {
+	ok        bool
+	tokens    int
+	timeToAct time.Time
+	lim       reservationCanceler
+	once      sync.Once
+}
+
+type reservationCanceler interface {
+	cancelReservation(r *Reservation)
+}
+
+// reserver maxWait 안에 가능한 경우에만 예약하는 리미터
+type reserver interface {
+	reserveN(tokens int, maxWait time.Duration) *Reservation
+}
+
+// OK 예약에 성공했는지. 용량보다 많이 요청했거나 리미터가 멈췄으면 false
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay 지금부터 토큰을 쓸 수 있을 때까지 남은 시간
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	delay := r.timeToAct.Sub(now)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel 예약한 토큰을 리미터에 돌려준다. 여러 번 불러도 한 번만 처리된다
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.once.Do(func() {
+		r.lim.cancelReservation(r)
+	})
+}
+
+// wait tokens개를 예약하고 시간이 될 때까지 기다린다
+// ctx의 deadline 안에 불가능하면 바로 에러를 돌려주고, 기다리는 중에 ctx가 끝나면 예약을 취소한다
+func wait(ctx context.Context, lim reserver, tokens int) error {
+	if tokens <= 0 {
+		return fmt.Errorf("limiters: invalid token count %d", tokens)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	maxWait := InfDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = time.Until(deadline)
+	}
+
+	r := lim.reserveN(tokens, maxWait)
+	if !r.OK() {
+		if r.timeToAct.IsZero() {
+			return fmt.Errorf("limiters: %d tokens cannot be reserved", tokens)
+		}
+		return fmt.Errorf("limiters: waiting for %d tokens would exceed context deadline", tokens)
+	}
+
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// secondsToDuration 대기 시간 계산용. 너무 큰 값은 InfDuration으로 자른다
+func secondsToDuration(seconds float64) time.Duration {
+	d := seconds * float64(time.Second)
+	if d >= float64(InfDuration) {
+		return InfDuration
+	}
+	return time.Duration(math.Ceil(d))
+}
@@ -0,0 +1,118 @@
+package limiters
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncLimiterBase 전담 고루틴 없이 호출한 고루틴에서 바로 알고리즘을 실행한다
+// 상태는 짧은 뮤텍스 구간으로만 보호하고, 호출마다 채널을 만들지 않으므로 Allow에 할당이 없다
+type SyncLimiterBase struct {
+	alg     algorithm
+	mu      sync.Mutex
+	stopped atomic.Bool
+}
+
+func newSyncLimiterBase(alg algorithm) *SyncLimiterBase {
+	return &SyncLimiterBase{
+		alg: alg,
+	}
+}
+
+func (slb *SyncLimiterBase) Allow(tokens int) bool {
+	if tokens <= 0 || slb.stopped.Load() {
+		return false
+	}
+
+	slb.mu.Lock()
+	// 시각은 락 안에서 구해야 알고리즘이 보는 시간이 거꾸로 가지 않는다
+	_, ok := slb.alg.reserve(time.Now(), tokens, 0)
+	slb.mu.Unlock()
+
+	return ok
+}
+
+// AllowRetry Allow와 같지만 거절한 경우 토큰이 생길 때까지 남은 시간도 돌려준다
+func (slb *SyncLimiterBase) AllowRetry(tokens int) (bool, time.Duration) {
+	if tokens <= 0 || slb.stopped.Load() {
+		return false, 0
+	}
+
+	slb.mu.Lock()
+	now := time.Now()
+	timeToAct, ok := slb.alg.reserve(now, tokens, 0)
+	slb.mu.Unlock()
+
+	return ok, retryAfter(now, reserveResult{timeToAct: timeToAct, ok: ok})
+}
+
+func (slb *SyncLimiterBase) AllowUpTo(max int) int {
+	return slb.reserveUpTo(max).tokens
+}
+
+// reserveUpTo 가져간 토큰은 모두 같은 시각(now)에 차감되므로 그 시각으로 돌려줄 수 있다
+func (slb *SyncLimiterBase) reserveUpTo(max int) *Reservation {
+	r := &Reservation{lim: slb}
+	if max <= 0 || slb.stopped.Load() {
+		return r
+	}
+
+	slb.mu.Lock()
+	r.timeToAct = time.Now()
+	r.tokens = allowUpTo(slb.alg, r.timeToAct, max)
+	slb.mu.Unlock()
+
+	r.ok = r.tokens > 0
+	return r
+}
+
+// Reserve tokens개를 예약한다. 돌려받은 Reservation의 Delay만큼 기다린 뒤 사용하면 된다
+func (slb *SyncLimiterBase) Reserve(tokens int) *Reservation {
+	return slb.reserveN(tokens, InfDuration)
+}
+
+// Wait tokens개를 쓸 수 있을 때까지 기다린다. ctx가 먼저 끝나면 예약을 되돌리고 에러를 돌려준다
+func (slb *SyncLimiterBase) Wait(ctx context.Context, tokens int) error {
+	return wait(ctx, slb, tokens)
+}
+
+func (slb *SyncLimiterBase) reserveN(tokens int, maxWait time.Duration) *Reservation {
+	r := &Reservation{
+		tokens: tokens,
+		lim:    slb,
+	}
+	if tokens <= 0 || slb.stopped.Load() {
+		return r
+	}
+
+	slb.mu.Lock()
+	r.timeToAct, r.ok = slb.alg.reserve(time.Now(), tokens, maxWait)
+	slb.mu.Unlock()
+
+	return r
+}
+
+func (slb *SyncLimiterBase) cancelReservation(r *Reservation) {
+	slb.exec(func() {
+		slb.alg.cancel(time.Now(), r.timeToAct, r.tokens)
+	})
+}
+
+// exec fn을 락을 잡은 상태에서 실행한다. 리미터가 멈췄으면 false
+func (slb *SyncLimiterBase) exec(fn func()) bool {
+	if slb.stopped.Load() {
+		return false
+	}
+
+	slb.mu.Lock()
+	fn()
+	slb.mu.Unlock()
+
+	return true
+}
+
+func (slb *SyncLimiterBase) Stop() {
+	slb.stopped.Store(true)
+}
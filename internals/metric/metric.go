@@ -2,27 +2,34 @@ package metrics
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
+	"github.com/takaxis2/rate-limiter/internals/leader"
+	"github.com/takaxis2/rate-limiter/internals/limiters"
+	worker "github.com/takaxis2/rate-limiter/internals/service"
 	"github.com/takaxis2/rate-limiter/internals/storage"
 )
 
 type Metrics struct {
-	qm       *storage.QueueManager
-	queueKey string
+	mu     sync.Mutex
+	queues map[string]*storage.QueueManager // domain 라벨 -> 대기열
 	// Prometheus metrics
 	queueLength   *prometheus.GaugeVec
 	waitTime      *prometheus.HistogramVec
 	processTime   *prometheus.HistogramVec
 	requestStatus *prometheus.CounterVec
+	evicted       *prometheus.CounterVec
+	rejoined      *prometheus.CounterVec
+	leader        *prometheus.GaugeVec
 }
 
-func NewMetrics(qm *storage.QueueManager, queueKey string) *Metrics {
+// NewMetrics 프로세스에서 한 번만 만든다. 대기실마다 AddQueue로 등록한다
+func NewMetrics() *Metrics {
 	m := &Metrics{
-		qm:       qm,
-		queueKey: queueKey,
+		queues: make(map[string]*storage.QueueManager),
 
 		queueLength: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -57,6 +64,30 @@ func NewMetrics(qm *storage.QueueManager, queueKey string) *Metrics {
 			},
 			[]string{"domain", "status"},
 		),
+
+		evicted: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rate_limiter_queue_evicted_total",
+				Help: "Number of waiting clients evicted after their heartbeat lapsed",
+			},
+			[]string{"domain"},
+		),
+
+		rejoined: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rate_limiter_queue_rejoined_total",
+				Help: "Number of evicted clients restored to their reserved spot",
+			},
+			[]string{"domain"},
+		),
+
+		leader: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "rate_limiter_queue_leader",
+				Help: "1 if this instance holds the lease to admit clients from the queue",
+			},
+			[]string{"domain"},
+		),
 	}
 
 	// Prometheus에 메트릭 등록
@@ -65,11 +96,58 @@ func NewMetrics(qm *storage.QueueManager, queueKey string) *Metrics {
 		m.waitTime,
 		m.processTime,
 		m.requestStatus,
+		m.evicted,
+		m.rejoined,
+		m.leader,
 	)
 
 	return m
 }
 
+// AddQueue domain 라벨로 대기열 길이를 수집한다
+func (m *Metrics) AddQueue(domain string, qm *storage.QueueManager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues[domain] = qm
+}
+
+// Eviction domain 라벨로 기록하는 worker.EvictionObserver
+func (m *Metrics) Eviction(domain string) worker.EvictionObserver {
+	return &evictionObserver{m: m, domain: domain}
+}
+
+type evictionObserver struct {
+	m      *Metrics
+	domain string
+}
+
+func (o *evictionObserver) Evicted(n int64) {
+	o.m.evicted.WithLabelValues(o.domain).Add(float64(n))
+}
+
+func (o *evictionObserver) Rejoined() {
+	o.m.rejoined.WithLabelValues(o.domain).Inc()
+}
+
+// Leadership domain 라벨로 기록하는 leader.Observer
+func (m *Metrics) Leadership(domain string) leader.Observer {
+	m.leader.WithLabelValues(domain).Set(0)
+	return &leadershipObserver{m: m, domain: domain}
+}
+
+type leadershipObserver struct {
+	m      *Metrics
+	domain string
+}
+
+func (o *leadershipObserver) LeadershipChanged(leader bool) {
+	value := 0.0
+	if leader {
+		value = 1
+	}
+	o.m.leader.WithLabelValues(o.domain).Set(value)
+}
+
 func (m *Metrics) RecordMetrics(ctx context.Context, domain string, waitDuration, processDuration time.Duration, status string) {
 	// 대기 시간 기록
 	m.waitTime.WithLabelValues(domain).Observe(waitDuration.Seconds())
@@ -91,18 +169,76 @@ func (m *Metrics) StartMetricsCollection(ctx context.Context) {
 			return
 		case <-ticker.C:
 			// 모든 도메인의 큐 길이 업데이트
-			length, err := m.qm.GetTotalClients(ctx)
-			if err != nil {
-				if err == redis.Nil {
-					m.queueLength.WithLabelValues(m.queueKey).Set(-1)
-				} else {
-					m.queueLength.WithLabelValues(m.queueKey).Set(-2)
-				}
-				continue
+			m.mu.Lock()
+			for domain, qm := range m.queues {
+				m.collectQueueLength(ctx, domain, qm)
 			}
+			m.mu.Unlock()
+		}
+	}
+}
 
-			// 큐 길이 메트릭 업데이트
-			m.queueLength.WithLabelValues(m.queueKey).Set(float64(length))
+func (m *Metrics) collectQueueLength(ctx context.Context, domain string, qm *storage.QueueManager) {
+	length, err := qm.GetTotalClients(ctx)
+	if err != nil {
+		if err == redis.Nil {
+			m.queueLength.WithLabelValues(domain).Set(-1)
+		} else {
+			m.queueLength.WithLabelValues(domain).Set(-2)
 		}
+		return
+	}
+
+	// 큐 길이 메트릭 업데이트
+	m.queueLength.WithLabelValues(domain).Set(float64(length))
+}
+
+// LimiterMetrics 리미터 자체의 상태 (적응형 리미터의 현재 속도, 조정 횟수)
+type LimiterMetrics struct {
+	adaptiveRate        *prometheus.GaugeVec
+	adaptiveAdjustments *prometheus.CounterVec
+}
+
+func NewLimiterMetrics() *LimiterMetrics {
+	m := &LimiterMetrics{
+		adaptiveRate: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "rate_limiter_adaptive_tokens_per_second",
+				Help: "Current refill rate chosen by the adaptive limiter",
+			},
+			[]string{"domain"},
+		),
+
+		adaptiveAdjustments: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rate_limiter_adaptive_adjustments_total",
+				Help: "Number of rate adjustments made by the adaptive limiter",
+			},
+			[]string{"domain", "direction"},
+		),
+	}
+
+	prometheus.MustRegister(
+		m.adaptiveRate,
+		m.adaptiveAdjustments,
+	)
+
+	return m
+}
+
+// Adaptive domain 라벨로 기록하는 limiters.AdaptiveObserver
+func (m *LimiterMetrics) Adaptive(domain string) limiters.AdaptiveObserver {
+	return &adaptiveObserver{m: m, domain: domain}
+}
+
+type adaptiveObserver struct {
+	m      *LimiterMetrics
+	domain string
+}
+
+func (o *adaptiveObserver) RateAdjusted(rate float32, direction string) {
+	o.m.adaptiveRate.WithLabelValues(o.domain).Set(float64(rate))
+	if direction != "" {
+		o.m.adaptiveAdjustments.WithLabelValues(o.domain, direction).Inc()
 	}
 }
@@ -0,0 +1,135 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/takaxis2/rate-limiter/internals/broker"
+	"github.com/takaxis2/rate-limiter/internals/leader"
+	"github.com/takaxis2/rate-limiter/internals/limiters"
+	worker "github.com/takaxis2/rate-limiter/internals/service"
+	"github.com/takaxis2/rate-limiter/internals/storage"
+)
+
+// Room 이벤트 하나의 대기실. 대기열, 입장 속도 리미터, 워커를 따로 가진다
+type Room struct {
+	Name      string
+	TargetURL string // 입장한 사용자를 보낼 보호하는 서비스 주소
+	Queue     *storage.QueueManager
+	Limiter   limiters.RateLimiter
+	Worker    *worker.QueueWorker
+	Positions *worker.PositionNotifier
+	Evictor   *worker.Evictor
+	Leader    *leader.Elector // nil이면 모든 레플리카의 워커가 입장시킨다
+}
+
+// New evictions는 nil이어도 된다
+func New(name, targetURL string, qm *storage.QueueManager, limiter limiters.RateLimiter, eb broker.Broker, evictions worker.EvictionObserver) *Room {
+	return &Room{
+		Name:      name,
+		TargetURL: targetURL,
+		Queue:     qm,
+		Limiter:   limiter,
+		Worker:    worker.NewQueueWorker(qm, name, limiter, eb),
+		Positions: worker.NewPositionNotifier(qm, name, eb),
+		Evictor:   worker.NewEvictor(qm, name, eb, evictions),
+	}
+}
+
+// ElectLeader 레플리카 중 리더로 뽑힌 곳의 워커만 입장시킨다. Registry.Start 전에 부른다
+func (r *Room) ElectLeader(e *leader.Elector) {
+	r.Leader = e
+	r.Worker.RequireLeader(e)
+}
+
+// Registry 서버가 띄운 대기실 목록. 이름과 Host 헤더로 찾는다
+type Registry struct {
+	rooms []*Room
+	names map[string]*Room
+	hosts map[string]*Room
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]*Room),
+		hosts: make(map[string]*Room),
+	}
+}
+
+// Add hosts로 들어온 요청은 경로에 대기실 이름이 없어도 이 대기실로 보낸다
+func (rg *Registry) Add(room *Room, hosts ...string) error {
+	if _, ok := rg.names[room.Name]; ok {
+		return fmt.Errorf("room %q already exists", room.Name)
+	}
+	for _, host := range hosts {
+		if other, ok := rg.hosts[strings.ToLower(host)]; ok {
+			return fmt.Errorf("host %q is already used by room %q", host, other.Name)
+		}
+	}
+
+	rg.rooms = append(rg.rooms, room)
+	rg.names[room.Name] = room
+	for _, host := range hosts {
+		rg.hosts[strings.ToLower(host)] = room
+	}
+	return nil
+}
+
+func (rg *Registry) Get(name string) (*Room, bool) {
+	room, ok := rg.names[name]
+	return room, ok
+}
+
+// All 설정에 적힌 순서대로
+func (rg *Registry) All() []*Room {
+	return rg.rooms
+}
+
+// Resolve 요청이 들어갈 대기실을 찾는다
+// 경로의 {room}을 먼저 보고, 없으면 Host 헤더를 본다. 대기실이 하나뿐이면 그 대기실
+func (rg *Registry) Resolve(r *http.Request) (*Room, bool) {
+	if name := r.PathValue("room"); name != "" {
+		return rg.Get(name)
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if room, ok := rg.hosts[strings.ToLower(host)]; ok {
+		return room, true
+	}
+
+	if len(rg.rooms) == 1 {
+		return rg.rooms[0], true
+	}
+	return nil, false
+}
+
+// Start 대기실마다 워커, 순번 알림, 이탈자 정리를 띄운다
+func (rg *Registry) Start(ctx context.Context) {
+	for _, room := range rg.rooms {
+		go room.Worker.Start(ctx)
+		go room.Positions.Start(ctx)
+		go room.Evictor.Start(ctx)
+		if room.Leader != nil {
+			go room.Leader.Start(ctx)
+		}
+	}
+}
+
+// Stop 워커, 순번 알림, 이탈자 정리, 리미터를 멈추고 리더 임대를 돌려준다
+func (rg *Registry) Stop() {
+	for _, room := range rg.rooms {
+		room.Worker.Stop()
+		room.Positions.Stop()
+		room.Evictor.Stop()
+		if room.Leader != nil {
+			room.Leader.Stop()
+		}
+		room.Limiter.Stop()
+	}
+}
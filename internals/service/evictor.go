@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/takaxis2/rate-limiter/internals/broker"
+	"github.com/takaxis2/rate-limiter/internals/storage"
+)
+
+const (
+	defaultHeartbeatInterval = 10 * time.Second
+	defaultHeartbeatGrace    = 30 * time.Second
+	defaultReserveWindow     = 2 * time.Minute
+)
+
+// evictBatch 스크립트 한 번에 빼는 클라이언트 수. 레디스를 오래 막지 않도록 나눠서 뺀다
+const evictBatch = 1000
+
+// EvictionObserver 대기열에서 빼거나 되돌릴 때마다 불린다
+type EvictionObserver interface {
+	Evicted(n int64)
+	Rejoined()
+}
+
+// Evictor 탭을 닫고 떠난 대기자를 대기열에서 뺀다. 워커가 없는 사람에게 토큰을 쓰지 않게 한다
+// 이 인스턴스에 SSE/웹소켓으로 연결된 사용자는 틱마다 한 번에 하트비트를 갱신하고,
+// 연결 없이 기다리는 클라이언트는 Keepalive로 직접 갱신한다
+// grace 동안 하트비트가 없으면 빼고, reserve 안에 돌아오면 원래 자리로 되돌린다
+type Evictor struct {
+	qm       *storage.QueueManager
+	room     string
+	eb       broker.Broker
+	observer EvictionObserver
+	shutdown chan struct{}
+
+	interval atomic.Int64
+	grace    atomic.Int64
+	reserve  atomic.Int64
+}
+
+// NewEvictor observer는 nil이어도 된다
+func NewEvictor(qm *storage.QueueManager, room string, eb broker.Broker, observer EvictionObserver) *Evictor {
+	e := &Evictor{
+		qm:       qm,
+		room:     room,
+		eb:       eb,
+		observer: observer,
+		shutdown: make(chan struct{}),
+	}
+	e.interval.Store(int64(defaultHeartbeatInterval))
+	e.grace.Store(int64(defaultHeartbeatGrace))
+	e.reserve.Store(int64(defaultReserveWindow))
+	return e
+}
+
+// UpdateConfig 실행 중에도 바꿀 수 있다. 연결된 사용자가 빠지지 않도록 grace는 interval보다 길어야 한다
+func (e *Evictor) UpdateConfig(interval, grace, reserve time.Duration) error {
+	if interval <= 0 || grace <= interval {
+		return fmt.Errorf("need 0 < heartbeatInterval < heartbeatGrace, got %v / %v", interval, grace)
+	}
+	if reserve < 0 {
+		return fmt.Errorf("reserveWindow must not be negative")
+	}
+	e.interval.Store(int64(interval))
+	e.grace.Store(int64(grace))
+	e.reserve.Store(int64(reserve))
+	return nil
+}
+
+func (e *Evictor) Start(ctx context.Context) {
+	interval := time.Duration(e.interval.Load())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		if next := time.Duration(e.interval.Load()); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+
+		if err := e.sweep(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error evicting clients for room %s: %v", e.room, err)
+		}
+	}
+}
+
+// sweep 연결된 사용자의 하트비트를 갱신하고 끊긴 사용자를 뺀다
+// 인스턴스마다 자기 연결만 갱신하므로, 다른 인스턴스가 먼저 빼지 않도록 grace는 interval보다 길다
+func (e *Evictor) sweep(ctx context.Context) error {
+	if users := e.eb.Users(e.room); len(users) > 0 {
+		if err := e.qm.Touch(ctx, users...); err != nil {
+			return err
+		}
+	}
+
+	before := time.Now().Add(-time.Duration(e.grace.Load()))
+	reserve := time.Duration(e.reserve.Load())
+	for {
+		scanned, evicted, err := e.qm.EvictStale(ctx, before, reserve, evictBatch)
+		if err != nil {
+			return err
+		}
+		if evicted > 0 && e.observer != nil {
+			e.observer.Evicted(evicted)
+		}
+		// 끊긴 하트비트가 더 남아있으면 바로 이어서 뺀다
+		// 뺀 수로 판단하면 이미 대기열에 없는 하트비트만 남은 묶음에서 멈춘다
+		if scanned < evictBatch {
+			return nil
+		}
+	}
+}
+
+// Keepalive 클라이언트가 아직 기다리고 있다. 빠진 지 얼마 안 됐으면 원래 자리로 되돌린다
+// 대기열에 있으면 true
+func (e *Evictor) Keepalive(ctx context.Context, clientID string) (bool, error) {
+	waiting, rejoined, err := e.qm.Heartbeat(ctx, clientID)
+	if rejoined && e.observer != nil {
+		e.observer.Rejoined()
+	}
+	return waiting, err
+}
+
+// Stop Start 루프를 멈춘다
+func (e *Evictor) Stop() {
+	close(e.shutdown)
+}
@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/takaxis2/rate-limiter/internals/broker"
+	"github.com/takaxis2/rate-limiter/internals/storage"
+)
+
+// defaultPositionInterval 대기 순번을 다시 보내는 간격
+const defaultPositionInterval = 5 * time.Second
+
+// rateSmoothing 입장 속도 EWMA 가중치. 클수록 최근 값을 많이 반영한다
+const rateSmoothing = 0.3
+
+// QueueStatus 대기 중인 사용자에게 보내는 현재 상태
+type QueueStatus struct {
+	Position      int     `json:"position"`       // 대기열에서의 위치 (1부터)
+	EstimatedWait float64 `json:"estimated_wait"` // 예상 대기 시간(초). 입장 속도를 아직 모르면 -1
+	QueueLength   int     `json:"queue_length"`   // 전체 대기열 길이
+}
+
+// PositionNotifier 이 인스턴스에 연결된 대기자에게 주기적으로 순번과 예상 대기 시간을 보낸다
+// 연결된 사용자들의 순번은 파이프라인으로 묶어서 조회하므로 대기자가 많아도 틱마다 레디스 왕복은 몇 번뿐이다
+// 예상 대기 시간은 레디스의 누적 입장 수로 잰 입장 속도(EWMA)로 계산한다
+type PositionNotifier struct {
+	qm       *storage.QueueManager
+	room     string
+	eb       broker.Broker
+	interval atomic.Int64
+	shutdown chan struct{}
+
+	// Start 고루틴에서만 쓴다
+	lastTotal int64
+	lastTime  time.Time
+	rate      float64 // 초당 입장 수
+}
+
+func NewPositionNotifier(qm *storage.QueueManager, room string, eb broker.Broker) *PositionNotifier {
+	n := &PositionNotifier{
+		qm:       qm,
+		room:     room,
+		eb:       eb,
+		shutdown: make(chan struct{}),
+	}
+	n.interval.Store(int64(defaultPositionInterval))
+	return n
+}
+
+// UpdateConfig 실행 중에도 바꿀 수 있다. 다음 틱부터 적용된다
+func (n *PositionNotifier) UpdateConfig(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("positionInterval must be greater than 0")
+	}
+	n.interval.Store(int64(interval))
+	return nil
+}
+
+func (n *PositionNotifier) Start(ctx context.Context) {
+	interval := time.Duration(n.interval.Load())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		if next := time.Duration(n.interval.Load()); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+
+		if err := n.notify(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error notifying positions for room %s: %v", n.room, err)
+		}
+	}
+}
+
+func (n *PositionNotifier) notify(ctx context.Context) error {
+	if err := n.observeRate(ctx); err != nil {
+		return err
+	}
+
+	users := n.eb.Users(n.room)
+	if len(users) == 0 {
+		return nil
+	}
+
+	total, err := n.qm.GetTotalClients(ctx)
+	if err != nil {
+		return err
+	}
+	positions, err := n.qm.GetClientPositions(ctx, users)
+	if err != nil {
+		return err
+	}
+
+	for userID, pos := range positions {
+		n.eb.Notify(broker.Event{
+			Type:   broker.EventPosition,
+			Room:   n.room,
+			UserID: userID,
+			Data: QueueStatus{
+				Position:      int(pos) + 1,
+				EstimatedWait: n.estimate(pos + 1),
+				QueueLength:   int(total),
+			},
+		})
+	}
+	return nil
+}
+
+// observeRate 지난 틱 이후 늘어난 입장 수로 입장 속도를 갱신한다
+func (n *PositionNotifier) observeRate(ctx context.Context) error {
+	total, err := n.qm.AdmittedTotal(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !n.lastTime.IsZero() && total >= n.lastTotal {
+		current := float64(total-n.lastTotal) / now.Sub(n.lastTime).Seconds()
+		if n.rate == 0 {
+			n.rate = current
+		} else {
+			n.rate = rateSmoothing*current + (1-rateSmoothing)*n.rate
+		}
+	}
+	n.lastTotal = total
+	n.lastTime = now
+	return nil
+}
+
+// estimate 앞에 있는 사람 수(본인 포함)를 입장 속도로 나눈다
+func (n *PositionNotifier) estimate(ahead int64) float64 {
+	if n.rate <= 0 {
+		return -1
+	}
+	return math.Ceil(float64(ahead) / n.rate)
+}
+
+// Stop Start 루프를 멈춘다
+func (n *PositionNotifier) Stop() {
+	close(n.shutdown)
+}
@@ -2,7 +2,9 @@ package worker
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -11,72 +13,177 @@ import (
 	"github.com/takaxis2/rate-limiter/internals/storage"
 )
 
+// defaultPollInterval 대기열이 비어있을 때 다시 확인하는 간격
+const defaultPollInterval = 1000 * time.Millisecond
+
+// defaultTickInterval 대기자가 있을 때 토큰을 확인해서 입장시키는 간격
+const defaultTickInterval = 100 * time.Millisecond
+
+// defaultMaxBatch 한 틱에 입장시키는 최대 인원
+const defaultMaxBatch = 500
+
+// defaultAdmitTTL 입장 처리 후 통과권을 받아갈 수 있는 시간
+const defaultAdmitTTL = 5 * time.Minute
+
+// Leadership 레플리카 중 이 인스턴스가 대기열을 비울 차례인지 (leader.Elector)
+type Leadership interface {
+	IsLeader() bool
+}
+
+// QueueWorker 틱마다 리미터에 남은 토큰만큼 대기열 앞에서 한 번에 꺼내 입장시킨다
 type QueueWorker struct {
-	qm       *storage.QueueManager
-	key      string
-	limiter  limiters.RateLimiter
-	shutdown chan struct{}
-	eb       *broker.EventBroker
+	qm           *storage.QueueManager
+	key          string
+	limiter      limiters.RateLimiter
+	leadership   Leadership
+	shutdown     chan struct{}
+	eb           broker.Broker
+	pollInterval atomic.Int64
+	tickInterval atomic.Int64
+	maxBatch     atomic.Int64
+	admitTTL     atomic.Int64
 }
 
-func NewQueueWorker(qm *storage.QueueManager, key string, limiter limiters.RateLimiter, eb *broker.EventBroker) *QueueWorker {
-	return &QueueWorker{
+func NewQueueWorker(qm *storage.QueueManager, key string, limiter limiters.RateLimiter, eb broker.Broker) *QueueWorker {
+	w := &QueueWorker{
 		qm:       qm,
 		key:      key,
 		limiter:  limiter,
 		eb:       eb,
 		shutdown: make(chan struct{}),
 	}
+	w.pollInterval.Store(int64(defaultPollInterval))
+	w.tickInterval.Store(int64(defaultTickInterval))
+	w.maxBatch.Store(defaultMaxBatch)
+	w.admitTTL.Store(int64(defaultAdmitTTL))
+	return w
+}
+
+// RequireLeader 리더일 때만 입장시킨다. 레플리카마다 워커가 돌아도 입장 속도가 레플리카 수만큼 늘지 않는다
+// Start 전에 부른다
+// 리미터가 메모리 버킷이면 리더가 아닌 동안 버킷이 가득 차 있으므로, 리더가 바뀌면 새 리더가 capacity만큼 한 번에 입장시킨다
+// 이어받을 때 몰리지 않게 하려면 레디스 버킷(rateLimit.store: redis)을 써서 리더끼리 버킷을 이어 쓴다
+func (w *QueueWorker) RequireLeader(l Leadership) {
+	w.leadership = l
+}
+
+// UpdateConfig 실행 중에도 바꿀 수 있다. 다음 틱부터 적용된다
+func (w *QueueWorker) UpdateConfig(pollInterval, tickInterval time.Duration, maxBatch int, admitTTL time.Duration) error {
+	if pollInterval <= 0 || tickInterval <= 0 || admitTTL <= 0 {
+		return fmt.Errorf("pollInterval, tickInterval and admitTTL must be greater than 0")
+	}
+	if maxBatch <= 0 {
+		return fmt.Errorf("maxBatch must be greater than 0")
+	}
+	w.pollInterval.Store(int64(pollInterval))
+	w.tickInterval.Store(int64(tickInterval))
+	w.maxBatch.Store(int64(maxBatch))
+	w.admitTTL.Store(int64(admitTTL))
+	return nil
 }
 
 func (w *QueueWorker) Start(ctx context.Context) {
-	ticker := time.NewTicker(5000 * time.Millisecond)
-	for {
+	// Stop이 불리면 쉬는 중이라도 바로 빠져나오도록 ctx를 취소한다
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
 		select {
-		case <-ctx.Done():
-			return
 		case <-w.shutdown:
-			return
-		case <-ticker.C:
-			clients, err := w.qm.GetTopNClients(ctx, 1)
-			if err != nil && err != redis.Nil {
-				log.Printf("Error fetching from Redis: %v", err)
-				continue
-			}
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-			if len(clients) == 0 {
-				continue
+	for {
+		admitted, waiting, err := w.admitBatch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
+			log.Printf("Error admitting clients for room %s: %v", w.key, err)
+		}
 
-			if clients[0] != "" {
-				if w.limiter.Allow(1) {
-					//채널, sse
-					w.eb.Publish(clients[0])
-					w.qm.RemoveClient(ctx, clients[0])
-				}
-				// else {
-				// 	// 토큰이 없으면 다시 맨 앞에 삽입
-				// 	// w.rdb.LPush(ctx, w.keyPrefix+"queue", clientId)
-				// }
-			}
+		// 한 번에 다 못 꺼냈으면 토큰이 남아있을 수 있으므로 바로 다시 꺼낸다
+		if err == nil && int64(admitted) == w.maxBatch.Load() {
+			continue
+		}
 
-			// default:
-			// 	clientId, err := w.rdb.LPop(ctx,w.keyPrefix+"queue").Result()
-			// 	if err != nil && err != redis.Nil{
-			// 		log.Printf("Error fetching from Redis: %v", err)
-			// 		continue
-			// 	}
-
-			// 	if clientId != "" {
-			// 		if w.limiter.Allow(1){
-			// 			//채널, sse
-			// 		} else{
-			// 			// 토큰이 없으면 다시 맨 앞에 삽입
-			// 			w. rdb.LPush(ctx, w.keyPrefix+"queue", clientId)
-			// 		}
-			// 	}
-			// 	time.Sleep(100 * time.Millisecond) // 대기시간
-			// }
+		interval := w.tickInterval.Load()
+		if !waiting {
+			interval = w.pollInterval.Load()
 		}
+		if !idle(ctx, time.Duration(interval)) {
+			return
+		}
+	}
+}
+
+// admitBatch 지금 쓸 수 있는 토큰만큼 입장시킨다
+// 입장시킨 수와 (입장시키기 전) 대기자가 있었는지를 돌려준다
+func (w *QueueWorker) admitBatch(ctx context.Context) (int, bool, error) {
+	if w.leadership != nil && !w.leadership.IsLeader() {
+		// 다른 레플리카가 비운다. 리더가 죽으면 이어받을 수 있도록 pollInterval마다 다시 본다
+		return 0, false, nil
+	}
+
+	waiting, err := w.qm.GetTotalClients(ctx)
+	if err != nil && err != redis.Nil {
+		return 0, false, err
 	}
+	if waiting == 0 {
+		return 0, false, nil
+	}
+
+	// 대기자보다 많이 가져가면 바로 입장하는 요청(RequestHandler)이 쓸 토큰이 없어진다
+	grant := limiters.ReserveUpTo(w.limiter, int(min(waiting, w.maxBatch.Load())))
+	n := grant.Tokens()
+	if n == 0 {
+		return 0, true, nil
+	}
+
+	// 꺼내면서 입장 표시까지 남기므로 이벤트를 받은 브라우저가 바로 통과권을 받아갈 수 있다
+	// 다른 인스턴스가 먼저 꺼내가거나 그 사이 대기자가 나가면 n명보다 적게 나온다. 쓰지 않은 토큰은 돌려준다
+	clients, err := w.qm.PopN(ctx, int64(n), time.Duration(w.admitTTL.Load()))
+	if err != nil {
+		grant.Refund(n)
+		return 0, true, err
+	}
+	grant.Refund(n - len(clients))
+
+	//채널, sse
+	// 이벤트를 못 보내도 입장 표시는 남아있으므로 다시 연결하거나 대기 페이지를 열면 입장한다
+	var publishErr error
+	for _, client := range clients {
+		if err := w.eb.Publish(broker.Event{Type: broker.EventAdmitted, Room: w.key, UserID: client.ID}); err != nil && publishErr == nil {
+			publishErr = err
+		}
+	}
+	if len(clients) > 0 {
+		// 대기실 전체에는 빠진 인원만 한 번 알린다
+		if err := w.eb.Publish(broker.Event{Type: broker.EventDequeued, Room: w.key, Data: len(clients)}); err != nil && publishErr == nil {
+			publishErr = err
+		}
+	}
+	if publishErr != nil {
+		log.Printf("Error publishing admission events for room %s: %v", w.key, publishErr)
+	}
+	return len(clients), true, nil
+}
+
+// idle d만큼 쉰다. 그 사이 ctx가 끝나면 false
+func idle(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Stop Start 루프를 멈춘다
+func (w *QueueWorker) Stop() {
+	close(w.shutdown)
 }
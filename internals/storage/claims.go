@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ClaimLedger 이미 쓴 등급 클레임을 기억한다. 모든 대기실과 레플리카가 같은 기록을 본다
+type ClaimLedger struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func NewClaimLedger(rdb *redis.Client) *ClaimLedger {
+	return &ClaimLedger{rdb: rdb, prefix: "claim_used:"}
+}
+
+// NewReportLedger 이미 받은 결과 보고의 서명을 기억한다. 같은 보고를 다시 보내도 한 번만 반영된다
+func NewReportLedger(rdb *redis.Client) *ClaimLedger {
+	return &ClaimLedger{rdb: rdb, prefix: "report_used:"}
+}
+
+// key 쓴 클레임의 Nonce (또는 보고 서명). 만료되면 같이 지워진다
+func (l *ClaimLedger) key(nonce string) string {
+	return l.prefix + nonce
+}
+
+// Spend 처음 쓰는 클레임이면 기록하고 true, 이미 쓴 클레임이면 false
+// 기록은 클레임 만료 시각까지만 남긴다 (그 뒤로는 서명 검증에서 만료로 걸러진다)
+func (l *ClaimLedger) Spend(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return l.rdb.SetNX(ctx, l.key(nonce), 1, ttl).Result()
+}
+
+// Release Spend 기록을 지운다. 쓴 클레임으로 대기열에 넣지 못했을 때 다시 쓸 수 있게 한다
+func (l *ClaimLedger) Release(ctx context.Context, nonce string) error {
+	return l.rdb.Del(ctx, l.key(nonce)).Err()
+}
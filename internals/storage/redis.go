@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,30 +16,197 @@ type QueueManager struct {
 
 func NewQueueManager(rdb *redis.Client, queueKey string) *QueueManager {
 	return &QueueManager{
-		rdb: rdb,
+		rdb:      rdb,
+		queueKey: queueKey,
 	}
 }
 
-// AddClient 새로운 클라이언트를 대기열에 추가
-func (qm *QueueManager) AddClient(ctx context.Context, clientID string) error {
-	// 현재 timestamp를 score로 사용하여 자연스러운 순서 부여
-	score := float64(time.Now().UnixNano())
-	return qm.rdb.ZAdd(ctx, qm.queueKey, redis.Z{
-		Score:  score,
-		Member: clientID,
-	}).Err()
+// tierScale 등급 하나가 차지하는 score 범위. 등급 안의 순번이 이보다 작아야 등급끼리 섞이지 않는다
+// maxTiers(100) * tierScale이 2^53보다 작아서 float64 score로도 순번이 정확하다
+const tierScale = 1e13
+
+// queueScore 등급 * tierScale + 들어온 순번. 앞 등급이 먼저, 같은 등급 안에서는 먼저 온 사람이 먼저
+func queueScore(rank int, seq int64) float64 {
+	return float64(rank)*tierScale + float64(seq)
+}
+
+// seqKey 대기열에 들어온 순번. 같은 밀리초에 들어와도 들어온 순서대로 선다
+func (qm *QueueManager) seqKey() string {
+	return "seq:" + qm.queueKey
+}
+
+// addScript 순번을 받아서 대기열에 넣는다. 순번을 받는 것과 넣는 것이 한 번에 일어나므로 인스턴스가 여러 개여도 순서가 섞이지 않는다
+//
+// KEYS[1] 대기열, KEYS[2] 하트비트, KEYS[3] 순번
+// ARGV[1] 클라이언트 ID, ARGV[2] 등급 * tierScale, ARGV[3] 현재 시각(ms)
+var addScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[3])
+redis.call('ZADD', KEYS[1], string.format('%d', tonumber(ARGV[2]) + seq), ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return seq
+`)
+
+// AddClient 새로운 클라이언트를 대기열에 추가. rank가 작을수록 앞에 선다 (config.QueueConfig.TierRank)
+// 들어온 순간을 첫 하트비트로 본다
+func (qm *QueueManager) AddClient(ctx context.Context, clientID string, rank int) error {
+	return addScript.Run(ctx, qm.rdb,
+		[]string{qm.queueKey, qm.heartbeatKey(), qm.seqKey()},
+		clientID, int64(queueScore(rank, 0)), time.Now().UnixMilli(),
+	).Err()
+}
+
+// RemoveClient 클라이언트를 대기열에서 제거. 대기열에 있었으면 true
+func (qm *QueueManager) RemoveClient(ctx context.Context, clientID string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := qm.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, qm.queueKey, clientID)
+		pipe.ZRem(ctx, qm.heartbeatKey(), clientID)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
+
+// heartbeatKey 대기 중인 클라이언트의 마지막 하트비트 (score는 unix ms)
+func (qm *QueueManager) heartbeatKey() string {
+	return "heartbeat:" + qm.queueKey
+}
+
+// reservedKey 하트비트가 끊겨 빠진 클라이언트의 원래 score. 만료 전에 돌아오면 그 자리로 돌려놓는다
+func (qm *QueueManager) reservedKey(clientID string) string {
+	return "reserved:" + qm.queueKey + ":" + clientID
+}
+
+// Touch 여러 클라이언트의 하트비트를 한 번에 갱신한다. 하트비트가 없는 (이미 빠진) 클라이언트는 건너뛴다
+func (qm *QueueManager) Touch(ctx context.Context, clientIDs ...string) error {
+	score := float64(time.Now().UnixMilli())
+	for start := 0; start < len(clientIDs); start += clientBatch {
+		batch := clientIDs[start:min(start+clientBatch, len(clientIDs))]
+
+		members := make([]redis.Z, len(batch))
+		for i, id := range batch {
+			members[i] = redis.Z{Score: score, Member: id}
+		}
+		err := qm.rdb.ZAddArgs(ctx, qm.heartbeatKey(), redis.ZAddArgs{
+			XX:      true,
+			Members: members,
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evictScript 하트비트가 끊긴 클라이언트를 대기열에서 빼고 원래 자리를 잠시 보관한다
+//
+// KEYS[1] 대기열, KEYS[2] 하트비트
+// ARGV[1] 이 시각(ms) 이전 하트비트는 끊긴 것으로 본다, ARGV[2] 자리 보관 시간(ms), 0이면 보관하지 않는다
+// ARGV[3] 한 번에 뺄 최대 수, ARGV[4] 보관 키 접두사
+//
+// 반환값 {살펴본 하트비트 수, 뺀 클라이언트 수}. 하트비트만 남고 대기열에 없는 클라이언트는 뺀 수에 들어가지 않는다
+var evictScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[3]))
+local reserve = tonumber(ARGV[2])
+local evicted = 0
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[2], id)
+	local score = redis.call('ZSCORE', KEYS[1], id)
+	if score then
+		redis.call('ZREM', KEYS[1], id)
+		if reserve > 0 then
+			redis.call('SET', ARGV[4] .. id, score, 'PX', reserve)
+		end
+		evicted = evicted + 1
+	end
+end
+return {#ids, evicted}
+`)
+
+// EvictStale before 이전에 마지막 하트비트를 보낸 클라이언트를 limit명까지 살펴보고 대기열에 있으면 뺀다
+// reserve 동안은 Heartbeat로 원래 자리에 돌아올 수 있다
+// scanned가 limit보다 작으면 끊긴 하트비트가 더 남아있지 않다
+func (qm *QueueManager) EvictStale(ctx context.Context, before time.Time, reserve time.Duration, limit int64) (scanned, evicted int64, err error) {
+	res, err := evictScript.Run(ctx, qm.rdb,
+		[]string{qm.queueKey, qm.heartbeatKey()},
+		before.UnixMilli(), reserve.Milliseconds(), limit, qm.reservedKey(""),
+	).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) != 2 {
+		return 0, 0, fmt.Errorf("unexpected evict result: %v", res)
+	}
+	return res[0], res[1], nil
 }
 
-// RemoveClient 클라이언트를 대기열에서 제거
-func (qm *QueueManager) RemoveClient(ctx context.Context, clientID string) error {
-	return qm.rdb.ZRem(ctx, qm.queueKey, clientID).Err()
+// heartbeatScript 대기열에 있으면 하트비트를 갱신하고, 없으면 보관해둔 자리가 있을 때 원래 score로 되돌린다
+// KEYS[1] 대기열, KEYS[2] 하트비트, KEYS[3] 보관 키, ARGV[1] 클라이언트 ID, ARGV[2] 현재 시각(ms)
+//
+// 반환값 0 대기열에 없음, 1 대기 중, 2 되돌림
+var heartbeatScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+	return 1
+end
+local score = redis.call('GET', KEYS[3])
+if not score then
+	return 0
+end
+redis.call('DEL', KEYS[3])
+redis.call('ZADD', KEYS[1], score, ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+return 2
+`)
+
+// Heartbeat 클라이언트 하나의 하트비트. 빠진 지 얼마 안 됐으면 원래 자리로 되돌린다
+// waiting은 대기열에 있는지, rejoined는 이번에 되돌렸는지
+func (qm *QueueManager) Heartbeat(ctx context.Context, clientID string) (waiting, rejoined bool, err error) {
+	n, err := heartbeatScript.Run(ctx, qm.rdb,
+		[]string{qm.queueKey, qm.heartbeatKey(), qm.reservedKey(clientID)},
+		clientID, time.Now().UnixMilli(),
+	).Int64()
+	return n > 0, n == 2, err
 }
 
 // GetClientPosition 특정 클라이언트의 현재 대기 순서 조회 (0부터 시작)
+// 모든 등급이 한 sorted set에 있으므로 앞 등급 대기자까지 포함한 순서다
 func (qm *QueueManager) GetClientPosition(ctx context.Context, clientID string) (int64, error) {
 	return qm.rdb.ZRank(ctx, qm.queueKey, clientID).Result()
 }
 
+// clientBatch 한 번의 파이프라인(또는 명령)으로 보내는 클라이언트 수
+const clientBatch = 1000
+
+// GetClientPositions 여러 클라이언트의 대기 순서를 파이프라인으로 한 번에 조회 (0부터 시작)
+// 대기열에 없는 클라이언트는 결과에서 빠진다
+func (qm *QueueManager) GetClientPositions(ctx context.Context, clientIDs []string) (map[string]int64, error) {
+	positions := make(map[string]int64, len(clientIDs))
+	for start := 0; start < len(clientIDs); start += clientBatch {
+		batch := clientIDs[start:min(start+clientBatch, len(clientIDs))]
+
+		cmds := make([]*redis.IntCmd, len(batch))
+		_, err := qm.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, id := range batch {
+				cmds[i] = pipe.ZRank(ctx, qm.queueKey, id)
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+
+		for i, cmd := range cmds {
+			if pos, err := cmd.Result(); err == nil {
+				positions[batch[i]] = pos
+			}
+		}
+	}
+	return positions, nil
+}
+
 // GetTotalClients 전체 대기 중인 클라이언트 수 조회
 func (qm *QueueManager) GetTotalClients(ctx context.Context) (int64, error) {
 	return qm.rdb.ZCard(ctx, qm.queueKey).Result()
@@ -48,37 +217,124 @@ func (qm *QueueManager) GetTopNClients(ctx context.Context, n int64) ([]string,
 	return qm.rdb.ZRange(ctx, qm.queueKey, 0, n-1).Result()
 }
 
-// GetNextClient 다음 순서의 클라이언트 조회 및 제거
-func (qm *QueueManager) GetNextClient(ctx context.Context) (string, error) {
-	// 트랜잭션으로 처리하여 원자성 보장
-	txf := func(tx *redis.Tx) error {
-		// 첫 번째 클라이언트 조회
-		clients, err := tx.ZRange(ctx, qm.queueKey, 0, 0).Result()
-		if err != nil {
-			return err
-		}
-		if len(clients) == 0 {
-			return redis.Nil
-		}
+// Admitted PopN으로 꺼낸 클라이언트
+type Admitted struct {
+	ID    string
+	Score float64 // 대기열에서의 score (queueScore)
+}
 
-		// 클라이언트 제거
-		_, err = tx.ZRem(ctx, qm.queueKey, clients[0]).Result()
-		return err
+// popScript 앞에서부터 꺼내서 입장 집합에 넣는다. 꺼내기와 입장 표시가 한 번에 일어나므로
+// 여러 인스턴스의 워커가 동시에 돌아도 한 사람은 한 번만 입장한다
+//
+// KEYS[1] 대기열, KEYS[2] 하트비트, KEYS[3] 입장 집합, KEYS[4] 입장 수
+// ARGV[1] 꺼낼 최대 수, ARGV[2] 입장 표시를 남겨둘 시간(ms)
+//
+// 반환값 {ID, score, ID, score, ...} (ZPOPMIN과 같다)
+var popScript = redis.NewScript(`
+local popped = redis.call('ZPOPMIN', KEYS[1], tonumber(ARGV[1]))
+if #popped == 0 then
+	return popped
+end
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ttl = tonumber(ARGV[2])
+
+-- 통과권을 받아가지 않고 만료된 표시는 여기서 정리한다
+redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', now)
+for i = 1, #popped, 2 do
+	redis.call('ZREM', KEYS[2], popped[i])
+	redis.call('ZADD', KEYS[3], now + ttl, popped[i])
+end
+redis.call('PEXPIRE', KEYS[3], ttl)
+redis.call('INCRBY', KEYS[4], #popped / 2)
+return popped
+`)
+
+// PopN 앞에서부터 n명까지 꺼내서 ttl 동안 입장 표시를 남긴다. 레디스 왕복 한 번
+// 대기열이 비어있으면 빈 슬라이스
+func (qm *QueueManager) PopN(ctx context.Context, n int64, ttl time.Duration) ([]Admitted, error) {
+	res, err := popScript.Run(ctx, qm.rdb,
+		[]string{qm.queueKey, qm.heartbeatKey(), qm.admittedKey(), qm.admittedCountKey()},
+		n, ttl.Milliseconds(),
+	).StringSlice()
+	if err != nil {
+		return nil, err
 	}
 
-	// 낙관적 락을 사용한 트랜잭션 실행
-	for i := 0; i < 3; i++ {
-		clientID := ""
-		err := qm.rdb.Watch(ctx, txf, qm.queueKey)
-		if err == nil {
-			// 성공적으로 처리된 경우
-			return clientID, nil
-		}
-		if err == redis.TxFailedErr {
-			// 충돌이 발생한 경우 재시도
-			continue
+	admitted := make([]Admitted, 0, len(res)/2)
+	for i := 0; i+1 < len(res); i += 2 {
+		score, err := strconv.ParseFloat(res[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected score %q: %w", res[i+1], err)
 		}
+		admitted = append(admitted, Admitted{ID: res[i], Score: score})
+	}
+	return admitted, nil
+}
+
+// GetNextClient 다음 순서의 클라이언트를 꺼내서 ttl 동안 입장 표시를 남긴다. 대기열이 비어있으면 redis.Nil
+func (qm *QueueManager) GetNextClient(ctx context.Context, ttl time.Duration) (string, error) {
+	admitted, err := qm.PopN(ctx, 1, ttl)
+	if err != nil {
 		return "", err
 	}
-	return "", redis.TxFailedErr
+	if len(admitted) == 0 {
+		return "", redis.Nil
+	}
+	return admitted[0].ID, nil
+}
+
+// admittedKey 입장 처리된 클라이언트 (score는 만료 시각, unix ms). 대기열 키마다 따로 둔다
+func (qm *QueueManager) admittedKey() string {
+	return "admitted:" + qm.queueKey
+}
+
+// admittedCountKey 지금까지 입장시킨 수. 인스턴스 여러 개가 입장시켜도 전체 입장 속도를 볼 수 있다
+func (qm *QueueManager) admittedCountKey() string {
+	return "admitted_total:" + qm.queueKey
+}
+
+// AdmittedTotal 지금까지 입장시킨 수
+func (qm *QueueManager) AdmittedTotal(ctx context.Context) (int64, error) {
+	n, err := qm.rdb.Get(ctx, qm.admittedCountKey()).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// IsAdmitted 만료되지 않은 입장 표시가 있으면 true. 입장 이벤트를 놓친 클라이언트를 입장시킬 때 쓴다
+func (qm *QueueManager) IsAdmitted(ctx context.Context, clientID string) (bool, error) {
+	expires, err := qm.rdb.ZScore(ctx, qm.admittedKey(), clientID).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return expires > float64(time.Now().UnixMilli()), nil
+}
+
+// consumeScript 만료되지 않은 입장 표시가 있으면 지우고 1
+// KEYS[1] 입장 집합, ARGV[1] 클라이언트 ID
+var consumeScript = redis.NewScript(`
+local expires = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not expires then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+if tonumber(expires) <= now then
+	return 0
+end
+return 1
+`)
+
+// ConsumeAdmitted 입장 표시가 있으면 지우고 true. 통과권은 한 번만 받아갈 수 있다
+func (qm *QueueManager) ConsumeAdmitted(ctx context.Context, clientID string) (bool, error) {
+	n, err := consumeScript.Run(ctx, qm.rdb, []string{qm.admittedKey()}, clientID).Int64()
+	return n > 0, err
 }
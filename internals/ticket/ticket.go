@@ -0,0 +1,248 @@
+package ticket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/takaxis2/rate-limiter/internals/config"
+)
+
+// 티켓 종류. 다른 용도로 발급한 토큰을 바꿔 쓰지 못하게 서명 안에 넣는다
+const (
+	KindQueue = "queue" // 대기열에 들어간 사용자
+	KindPass  = "pass"  // 입장한 사용자. 보호하는 서비스가 확인한다
+	KindClaim = "claim" // 우선순위 등급. 보호하는 서비스가 발급하고 대기열에 들어올 때 확인한다
+)
+
+var (
+	ErrMalformed  = errors.New("ticket: malformed token")
+	ErrUnknownKey = errors.New("ticket: unknown signing key")
+	ErrSignature  = errors.New("ticket: invalid signature")
+	ErrExpired    = errors.New("ticket: expired")
+	ErrKind       = errors.New("ticket: wrong kind")
+)
+
+// Ticket 서명된 토큰에 들어가는 내용. 클라이언트는 읽을 수는 있지만 고칠 수는 없다
+type Ticket struct {
+	Kind      string `json:"kind"`
+	ID        string `json:"id"`             // 대기열 ID
+	Room      string `json:"room"`           // 대기실 이름
+	Tier      string `json:"tier,omitempty"` // 우선순위 등급. 클레임에만 쓴다
+	Nonce     string `json:"jti,omitempty"`  // 클레임마다 다른 값. 한 번 쓴 클레임을 다시 쓰지 못하게 기록한다
+	IssuedAt  int64  `json:"iat"`            // unix 초
+	ExpiresAt int64  `json:"exp"`            // unix 초
+}
+
+// Expires 쿠키 만료 시간에 쓴다
+func (t *Ticket) Expires() time.Time {
+	return time.Unix(t.ExpiresAt, 0)
+}
+
+// Signer HMAC-SHA256으로 티켓을 서명하고 검증한다
+// 토큰 형식: <키 ID>.<base64url(JSON)>.<base64url(HMAC)>
+// 새 티켓은 현재 키로 서명하고, 검증은 설정에 남아있는 모든 키로 한다 (키 교체 중에도 기존 티켓이 살아있다)
+type Signer struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+	ttl     time.Duration
+	passTTL time.Duration
+}
+
+func NewSigner(cfg config.TicketConfig) (*Signer, error) {
+	s := &Signer{}
+	if err := s.UpdateConfig(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// UpdateConfig 키를 교체한다. 이미 발급한 티켓은 서명한 키가 keys에 남아있는 동안 유효하다
+func (s *Signer) UpdateConfig(cfg config.TicketConfig) error {
+	if cfg.TTL <= 0 || cfg.PassTTL <= 0 {
+		return fmt.Errorf("ticket: ttl and passTTL must be greater than 0")
+	}
+	keys := make(map[string][]byte, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		keys[key.ID] = []byte(key.Secret)
+	}
+	if _, ok := keys[cfg.CurrentKey]; !ok {
+		return fmt.Errorf("ticket: current key %q is not in keys", cfg.CurrentKey)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = cfg.CurrentKey
+	s.keys = keys
+	s.ttl = cfg.TTL
+	s.passTTL = cfg.PassTTL
+	return nil
+}
+
+// Issue 대기열에 들어간 사용자에게 줄 티켓
+func (s *Signer) Issue(id, room string) (string, *Ticket, error) {
+	s.mu.RLock()
+	ttl := s.ttl
+	s.mu.RUnlock()
+
+	return s.issue(KindQueue, id, room, "", ttl)
+}
+
+// IssuePass 입장한 사용자에게 줄 통과권
+func (s *Signer) IssuePass(id, room string) (string, *Ticket, error) {
+	s.mu.RLock()
+	ttl := s.passTTL
+	s.mu.RUnlock()
+
+	return s.issue(KindPass, id, room, "", ttl)
+}
+
+// IssueClaim 등급 클레임. 같은 키를 가진 보호하는 서비스가 우대할 사용자에게 발급해서 대기실 주소에 붙인다
+// id는 그 서비스의 사용자 ID (기록용), room이 비어있으면 모든 대기실에서 쓸 수 있다
+// 클레임은 한 번만 쓸 수 있다. 대기실이 Nonce를 만료까지 기록해두고 다시 오면 거절한다
+func (s *Signer) IssueClaim(id, room, tier string, ttl time.Duration) (string, *Ticket, error) {
+	t := newTicket(KindClaim, id, room, tier, ttl)
+	t.Nonce = uuid.NewString()
+	return s.issueTicket(t)
+}
+
+func (s *Signer) issue(kind, id, room, tier string, ttl time.Duration) (string, *Ticket, error) {
+	return s.issueTicket(newTicket(kind, id, room, tier, ttl))
+}
+
+func newTicket(kind, id, room, tier string, ttl time.Duration) *Ticket {
+	now := time.Now()
+	return &Ticket{
+		Kind:      kind,
+		ID:        id,
+		Room:      room,
+		Tier:      tier,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+}
+
+func (s *Signer) issueTicket(t *Ticket) (string, *Ticket, error) {
+	token, err := s.Sign(t)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+// Sign 현재 키로 서명한다
+func (s *Signer) Sign(t *Ticket) (string, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.RLock()
+	kid, secret := s.current, s.keys[s.current]
+	s.mu.RUnlock()
+
+	signed := kid + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(secret, signed)), nil
+}
+
+// Verify 서명, 만료, 종류를 확인한다
+func (s *Signer) Verify(token, kind string) (*Ticket, error) {
+	signed, sig, ok := cutLast(token)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	kid, payload, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	s.mu.RLock()
+	secret, ok := s.keys[kid]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal(got, sign(secret, signed)) {
+		return nil, ErrSignature
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var t Ticket
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, ErrMalformed
+	}
+	if t.Kind != kind {
+		return nil, ErrKind
+	}
+	if time.Now().Unix() >= t.ExpiresAt {
+		return nil, ErrExpired
+	}
+	return &t, nil
+}
+
+// messagePrefix 메시지 서명에 붙인다. 같은 키로 만든 티켓 서명과 바꿔 쓰지 못하게 한다
+const messagePrefix = "message:"
+
+// SignMessage 티켓이 아닌 메시지(예: 결과 보고 본문)를 현재 키로 서명한다
+// 서명 형식: <키 ID>.<base64url(HMAC)>
+func (s *Signer) SignMessage(msg []byte) string {
+	s.mu.RLock()
+	kid, secret := s.current, s.keys[s.current]
+	s.mu.RUnlock()
+
+	return kid + "." + base64.RawURLEncoding.EncodeToString(sign(secret, messagePrefix+string(msg)))
+}
+
+// VerifyMessage SignMessage로 만든 서명인지 확인한다
+func (s *Signer) VerifyMessage(msg []byte, sig string) error {
+	kid, mac, ok := strings.Cut(sig, ".")
+	if !ok {
+		return ErrMalformed
+	}
+
+	s.mu.RLock()
+	secret, ok := s.keys[kid]
+	s.mu.RUnlock()
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil {
+		return ErrMalformed
+	}
+	if !hmac.Equal(got, sign(secret, messagePrefix+string(msg))) {
+		return ErrSignature
+	}
+	return nil
+}
+
+func sign(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func cutLast(s string) (before, after string, ok bool) {
+	i := strings.LastIndexByte(s, '.')
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
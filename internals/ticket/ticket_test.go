@@ -0,0 +1,135 @@
+package ticket
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takaxis2/rate-limiter/internals/config"
+)
+
+const (
+	testSecret    = "test-secret-test-secret-test-secret"
+	rotatedSecret = "rotated-secret-rotated-secret-rotated"
+)
+
+func newTestSigner(t *testing.T, current string, keys ...config.TicketKey) *Signer {
+	t.Helper()
+	s, err := NewSigner(config.TicketConfig{
+		TTL:        time.Minute,
+		PassTTL:    time.Minute,
+		CurrentKey: current,
+		Keys:       keys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	s := newTestSigner(t, "k1", config.TicketKey{ID: "k1", Secret: testSecret})
+	token, _, err := s.Issue("user-1", "concert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	// 내용을 바꾸면 서명이 맞지 않는다
+	forged, err := s.Sign(&Ticket{Kind: KindQueue, ID: "user-2", Room: "concert", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedPayload := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sig[0] ^= 0xff
+	tamperedSig := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	for name, tampered := range map[string]string{
+		"payload":   tamperedPayload,
+		"signature": tamperedSig,
+	} {
+		if _, err := s.Verify(tampered, KindQueue); !errors.Is(err, ErrSignature) {
+			t.Errorf("tampered %s: got %v, want ErrSignature", name, err)
+		}
+	}
+}
+
+func TestVerifyRejectsExpired(t *testing.T) {
+	s := newTestSigner(t, "k1", config.TicketKey{ID: "k1", Secret: testSecret})
+	token, err := s.Sign(&Ticket{Kind: KindQueue, ID: "user-1", Room: "concert", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(token, KindQueue); !errors.Is(err, ErrExpired) {
+		t.Fatalf("got %v, want ErrExpired", err)
+	}
+}
+
+func TestVerifyRejectsWrongKind(t *testing.T) {
+	s := newTestSigner(t, "k1", config.TicketKey{ID: "k1", Secret: testSecret})
+	token, _, err := s.Issue("user-1", "concert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 대기열 티켓을 통과권이나 클레임으로 쓸 수 없다
+	for _, kind := range []string{KindPass, KindClaim} {
+		if _, err := s.Verify(token, kind); !errors.Is(err, ErrKind) {
+			t.Errorf("queue ticket verified as %s: got %v, want ErrKind", kind, err)
+		}
+	}
+}
+
+func TestVerifyRejectsUnknownKey(t *testing.T) {
+	s := newTestSigner(t, "k1", config.TicketKey{ID: "k1", Secret: testSecret})
+	other := newTestSigner(t, "k2", config.TicketKey{ID: "k2", Secret: testSecret})
+	token, _, err := other.Issue("user-1", "concert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(token, KindQueue); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	oldKey := config.TicketKey{ID: "k1", Secret: testSecret}
+	newKey := config.TicketKey{ID: "k2", Secret: rotatedSecret}
+	s := newTestSigner(t, "k1", oldKey)
+	oldToken, _, err := s.Issue("user-1", "concert")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotate := func(keys ...config.TicketKey) {
+		t.Helper()
+		if err := s.UpdateConfig(config.TicketConfig{TTL: time.Minute, PassTTL: time.Minute, CurrentKey: "k2", Keys: keys}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 이전 키가 keys에 남아있는 동안은 이전 키로 서명한 티켓도 유효하다
+	rotate(oldKey, newKey)
+	if _, err := s.Verify(oldToken, KindQueue); err != nil {
+		t.Fatalf("ticket signed with the old key rejected during rotation: %v", err)
+	}
+	newToken, _, err := s.Issue("user-1", "concert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(newToken, "k2.") {
+		t.Fatalf("new ticket not signed with the current key: %s", newToken)
+	}
+
+	// 이전 키를 빼면 더 이상 받지 않는다
+	rotate(newKey)
+	if _, err := s.Verify(oldToken, KindQueue); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("ticket signed with a removed key: got %v, want ErrUnknownKey", err)
+	}
+	if _, err := s.Verify(newToken, KindQueue); err != nil {
+		t.Fatalf("ticket signed with the current key rejected: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+// Package admission 대기열 서버가 발급한 입장 통과권을 보호하는 서비스에서 확인한다
+//
+// 대기열 서버는 입장한 사용자를 targetURL?pass=<통과권>으로 보낸다.
+// Middleware는 쿼리의 통과권을 검증해서 쿠키로 옮기고, 이후 요청은 쿠키로 확인한다.
+//
+//	v := admission.NewRemoteVerifier("https://queue.example.com/api/verify", nil)
+//	http.Handle("/booking", admission.Middleware(v, admission.Options{
+//		WaitingRoomURL: "https://queue.example.com/api/request/concert",
+//		Rooms:          []string{"concert"},
+//	})(bookingHandler))
+//
+// 통과권은 사용자에게 묶여 있지 않다. 통과권(또는 쿠키)을 가진 사람은 누구든 만료(대기열 서버의 ticket.passTTL)까지 다시 쓸 수 있으므로
+// passTTL은 입장 후 필요한 만큼만 짧게 둔다
+//
+// 등급 클레임 발급과 결과 보고 서명은 Issuer로 한다 (대기열 서버와 같은 서명 키를 쓴다).
+//
+// 이 패키지는 다른 모듈에서 가져다 쓰므로 대기열 서버의 internal 패키지에 기대지 않는다
+package admission
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultParam 대기열 서버가 통과권을 붙여 보내는 쿼리 파라미터
+	DefaultParam = "pass"
+	// DefaultCookie Middleware가 통과권을 옮겨 담는 쿠키
+	DefaultCookie = "AdmissionPass"
+)
+
+var ErrInvalidPass = errors.New("admission: invalid pass")
+
+// Pass 입장이 확인된 사용자
+type Pass struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// VerifyResponse /api/verify 응답
+type VerifyResponse struct {
+	Valid bool   `json:"valid"`
+	Pass  *Pass  `json:"pass,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type Verifier interface {
+	Verify(ctx context.Context, pass string) (*Pass, error)
+}
+
+// RemoteVerifier 대기열 서버의 /api/verify에 물어본다. 서명 키를 나눠 가질 필요가 없다
+type RemoteVerifier struct {
+	verifyURL string
+	client    *http.Client
+}
+
+// NewRemoteVerifier client가 nil이면 5초 타임아웃 클라이언트를 쓴다
+func NewRemoteVerifier(verifyURL string, client *http.Client) *RemoteVerifier {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &RemoteVerifier{
+		verifyURL: verifyURL,
+		client:    client,
+	}
+}
+
+func (v *RemoteVerifier) Verify(ctx context.Context, pass string) (*Pass, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.verifyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+pass)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 대기열 서버는 유효하지 않은 통과권에 401을 준다. 그 밖의 응답(프록시 에러 페이지 등)은 본문을 믿지 않는다
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, ErrInvalidPass
+	default:
+		return nil, fmt.Errorf("admission: verify response: %s", resp.Status)
+	}
+
+	var result VerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("admission: verify response (%s): %w", resp.Status, err)
+	}
+	if !result.Valid || result.Pass == nil {
+		return nil, ErrInvalidPass
+	}
+	return result.Pass, nil
+}
+
+// LocalVerifier 대기열 서버와 같은 서명 키로 직접 검증한다. 요청마다 네트워크를 타지 않는다
+// 통과권 형식: <키 ID>.<base64url(JSON)>.<base64url(HMAC-SHA256)> (대기열 서버의 ticket.Signer와 같다)
+type LocalVerifier struct {
+	keys map[string][]byte
+}
+
+// passKind 통과권의 kind. 대기열 티켓이나 등급 클레임은 통과권으로 받지 않는다
+const passKind = "pass"
+
+// passClaims 통과권 JSON에서 확인하는 필드
+type passClaims struct {
+	Kind      string `json:"kind"`
+	ID        string `json:"id"`
+	Room      string `json:"room"`
+	ExpiresAt int64  `json:"exp"` // unix 초
+}
+
+// NewLocalVerifier keys는 키 ID -> 비밀 값. 대기열 서버의 ticket.keys와 같게 둔다 (교체 중인 이전 키 포함)
+func NewLocalVerifier(keys map[string]string) *LocalVerifier {
+	v := &LocalVerifier{keys: make(map[string][]byte, len(keys))}
+	for id, secret := range keys {
+		v.keys[id] = []byte(secret)
+	}
+	return v
+}
+
+func (v *LocalVerifier) Verify(ctx context.Context, pass string) (*Pass, error) {
+	i := strings.LastIndexByte(pass, '.')
+	if i < 0 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidPass)
+	}
+	signed, sig := pass[:i], pass[i+1:]
+	kid, payload, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidPass)
+	}
+
+	secret, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key", ErrInvalidPass)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidPass)
+	}
+	if !hmac.Equal(got, mac(secret, signed)) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidPass)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidPass)
+	}
+	var claims passClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidPass)
+	}
+	if claims.Kind != passKind {
+		return nil, fmt.Errorf("%w: wrong kind", ErrInvalidPass)
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidPass)
+	}
+	return &Pass{
+		ID:        claims.ID,
+		Room:      claims.Room,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+type Options struct {
+	WaitingRoomURL string   // 통과권이 없거나 만료되면 보낼 대기실 주소. 비어있으면 403
+	Rooms          []string // 받을 통과권의 대기실. 비어있으면 대기실을 확인하지 않는다 (한 대기열 서버에 대기실이 여럿이면 꼭 둔다)
+	Param          string   // 기본 DefaultParam
+	Cookie         string   // 기본 DefaultCookie
+}
+
+// allows 다른 대기실에서 받은 통과권으로 들어오지 못하게 한다
+func (o Options) allows(pass *Pass) bool {
+	return len(o.Rooms) == 0 || slices.Contains(o.Rooms, pass.Room)
+}
+
+type contextKey struct{}
+
+// FromContext Middleware를 통과한 요청의 통과권
+func FromContext(ctx context.Context) (*Pass, bool) {
+	pass, ok := ctx.Value(contextKey{}).(*Pass)
+	return pass, ok
+}
+
+// Middleware 통과권이 있는 요청만 next로 보낸다
+func Middleware(v Verifier, opts Options) func(http.Handler) http.Handler {
+	if opts.Param == "" {
+		opts.Param = DefaultParam
+	}
+	if opts.Cookie == "" {
+		opts.Cookie = DefaultCookie
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 대기열 서버에서 막 넘어온 경우. 쿠키로 옮기고 주소에서 통과권을 지운다
+			if token := r.URL.Query().Get(opts.Param); token != "" {
+				pass, err := v.Verify(r.Context(), token)
+				if err != nil || !opts.allows(pass) {
+					reject(w, r, opts)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     opts.Cookie,
+					Value:    token,
+					Path:     "/",
+					Expires:  pass.ExpiresAt,
+					HttpOnly: true,
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteLaxMode,
+				})
+				http.Redirect(w, r, withoutParam(r.URL, opts.Param), http.StatusSeeOther)
+				return
+			}
+
+			cookie, err := r.Cookie(opts.Cookie)
+			if err != nil {
+				reject(w, r, opts)
+				return
+			}
+			pass, err := v.Verify(r.Context(), cookie.Value)
+			if err != nil || !opts.allows(pass) {
+				reject(w, r, opts)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, pass)))
+		})
+	}
+}
+
+func reject(w http.ResponseWriter, r *http.Request, opts Options) {
+	if opts.WaitingRoomURL == "" {
+		http.Error(w, "Admission pass required", http.StatusForbidden)
+		return
+	}
+	http.Redirect(w, r, opts.WaitingRoomURL, http.StatusSeeOther)
+}
+
+func withoutParam(u *url.URL, param string) string {
+	q := u.Query()
+	q.Del(param)
+	stripped := *u
+	stripped.RawQuery = q.Encode()
+	return stripped.RequestURI()
+}
+
+// Append target에 통과권 쿼리를 붙인다. 대기열 서버가 리다이렉트할 때 쓴다
+func Append(target, pass string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(DefaultParam, pass)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// BearerToken Authorization 헤더의 토큰, 없으면 param 쿼리
+func BearerToken(r *http.Request, param string) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get(param)
+}
@@ -0,0 +1,163 @@
+package admission_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/takaxis2/rate-limiter/internals/config"
+	"github.com/takaxis2/rate-limiter/internals/ticket"
+	"github.com/takaxis2/rate-limiter/pkg/admission"
+)
+
+const testSecret = "test-secret-test-secret-test-secret"
+
+// 대기열 서버(ticket.Signer)가 발급한 통과권을 LocalVerifier가 그대로 검증하는지 확인한다
+func TestLocalVerifierAcceptsSignerPass(t *testing.T) {
+	signer, err := ticket.NewSigner(config.TicketConfig{
+		TTL:        time.Minute,
+		PassTTL:    time.Minute,
+		CurrentKey: "k1",
+		Keys:       []config.TicketKey{{ID: "k1", Secret: testSecret}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := admission.NewLocalVerifier(map[string]string{"k1": testSecret})
+
+	pass, _, err := signer.IssuePass("user-1", "concert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := v.Verify(context.Background(), pass)
+	if err != nil {
+		t.Fatalf("valid pass rejected: %v", err)
+	}
+	if got.ID != "user-1" || got.Room != "concert" {
+		t.Fatalf("got %+v, want user-1 in concert", got)
+	}
+
+	queueTicket, _, err := signer.Issue("user-1", "concert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(context.Background(), queueTicket); !errors.Is(err, admission.ErrInvalidPass) {
+		t.Fatalf("queue ticket accepted as a pass: %v", err)
+	}
+
+	other := admission.NewLocalVerifier(map[string]string{"k1": testSecret + "x"})
+	if _, err := other.Verify(context.Background(), pass); !errors.Is(err, admission.ErrInvalidPass) {
+		t.Fatalf("pass accepted with the wrong key: %v", err)
+	}
+}
+
+func TestRemoteVerifierChecksStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		body    string
+		invalid bool // ErrInvalidPass를 기대한다
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"valid":false,"error":"ticket: expired"}`, true},
+		// 프록시가 돌려준 에러 페이지가 우연히 JSON이어도 받지 않는다
+		{"bad gateway", http.StatusBadGateway, `{"valid":true,"pass":{"id":"x"}}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			_, err := admission.NewRemoteVerifier(srv.URL, nil).Verify(context.Background(), "pass")
+			if err == nil {
+				t.Fatal("non-200 response accepted")
+			}
+			if errors.Is(err, admission.ErrInvalidPass) != tc.invalid {
+				t.Fatalf("got %v, want ErrInvalidPass=%v", err, tc.invalid)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRejectsPassForOtherRoom(t *testing.T) {
+	signer, err := ticket.NewSigner(config.TicketConfig{
+		TTL:        time.Minute,
+		PassTTL:    time.Minute,
+		CurrentKey: "k1",
+		Keys:       []config.TicketKey{{ID: "k1", Secret: testSecret}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := admission.NewLocalVerifier(map[string]string{"k1": testSecret})
+	h := admission.Middleware(v, admission.Options{Rooms: []string{"concert"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		room string
+		want int
+	}{
+		{"concert", http.StatusOK},
+		// 같은 키로 서명했어도 다른 대기실의 통과권은 받지 않는다
+		{"flash-sale", http.StatusForbidden},
+	} {
+		pass, _, err := signer.IssuePass("user-1", tc.room)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/booking", nil)
+		r.AddCookie(&http.Cookie{Name: admission.DefaultCookie, Value: pass})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Fatalf("pass for %s: got status %d, want %d", tc.room, w.Code, tc.want)
+		}
+	}
+}
+
+// 보호하는 서비스가 Issuer로 만든 클레임과 보고 서명을 대기열 서버(ticket.Signer)가 받아들이는지 확인한다
+func TestIssuerMatchesSigner(t *testing.T) {
+	signer, err := ticket.NewSigner(config.TicketConfig{
+		TTL:        time.Minute,
+		PassTTL:    time.Minute,
+		CurrentKey: "k1",
+		Keys:       []config.TicketKey{{ID: "k1", Secret: testSecret}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := admission.NewIssuer("k1", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claim, err := issuer.IssueClaim("user-1", "concert", "vip", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := signer.Verify(claim, ticket.KindClaim)
+	if err != nil {
+		t.Fatalf("claim rejected: %v", err)
+	}
+	if got.ID != "user-1" || got.Room != "concert" || got.Tier != "vip" || got.Nonce == "" {
+		t.Fatalf("got %+v, want a vip claim for user-1 in concert with a nonce", got)
+	}
+	if other, _ := issuer.IssueClaim("user-1", "concert", "vip", time.Minute); other == claim {
+		t.Fatal("two claims share a nonce")
+	}
+
+	body := []byte(`{"latency_ms":120,"success":true}`)
+	r := httptest.NewRequest(http.MethodPost, "/api/report/concert", nil)
+	issuer.SignReport(r, "concert", body)
+	msg := r.Header.Get(admission.ReportTimestampHeader) + ".concert." + string(body)
+	if err := signer.VerifyMessage([]byte(msg), r.Header.Get(admission.ReportSignatureHeader)); err != nil {
+		t.Fatalf("report signature rejected: %v", err)
+	}
+	msg = r.Header.Get(admission.ReportTimestampHeader) + ".other." + string(body)
+	if err := signer.VerifyMessage([]byte(msg), r.Header.Get(admission.ReportSignatureHeader)); !errors.Is(err, ticket.ErrSignature) {
+		t.Fatalf("report signature accepted for another room: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package admission
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ClaimParam 대기실 주소에 등급 클레임을 붙이는 쿼리 파라미터
+	ClaimParam = "claim"
+	// ReportTimestampHeader 결과 보고를 서명한 시각 (unix 초)
+	ReportTimestampHeader = "X-Report-Timestamp"
+	// ReportSignatureHeader 결과 보고 서명 (<키 ID>.<base64url(HMAC)>)
+	ReportSignatureHeader = "X-Report-Signature"
+)
+
+// claimKind 등급 클레임의 kind
+const claimKind = "claim"
+
+// messagePrefix 대기열 서버의 메시지 서명과 같다. 같은 키로 만든 티켓 서명과 바꿔 쓰지 못하게 한다
+const messagePrefix = "message:"
+
+// Issuer 보호하는 서비스가 대기열 서버와 같은 서명 키로 등급 클레임을 발급하고 결과 보고를 서명한다
+// keyID, secret은 대기열 서버의 ticket.currentKey와 그 비밀 값으로 둔다
+type Issuer struct {
+	keyID  string
+	secret []byte
+}
+
+func NewIssuer(keyID, secret string) (*Issuer, error) {
+	if keyID == "" || secret == "" {
+		return nil, errors.New("admission: keyID and secret are required")
+	}
+	return &Issuer{keyID: keyID, secret: []byte(secret)}, nil
+}
+
+// claimPayload 대기열 서버의 ticket.Ticket과 같은 JSON
+type claimPayload struct {
+	Kind      string `json:"kind"`
+	ID        string `json:"id"`
+	Room      string `json:"room"`
+	Tier      string `json:"tier,omitempty"`
+	Nonce     string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// IssueClaim 우대할 사용자에게 줄 등급 클레임. 대기실 주소에 ?claim=<클레임>으로 붙인다
+// id는 이 서비스의 사용자 ID (기록용), room이 비어있으면 모든 대기실에서 쓸 수 있다. 클레임은 한 번만 쓸 수 있다
+func (i *Issuer) IssueClaim(id, room, tier string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	payload, err := json.Marshal(claimPayload{
+		Kind:      claimKind,
+		ID:        id,
+		Room:      room,
+		Tier:      tier,
+		Nonce:     uuid.NewString(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := i.keyID + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac(i.secret, signed)), nil
+}
+
+// SignReport 대기실 room에 보낼 결과 보고 본문을 서명해서 헤더에 넣는다
+// 서명한 메시지는 "<타임스탬프>.<대기실>.<본문>"이고 대기열 서버는 1분 넘게 차이 나거나 이미 받은 보고는 거절한다
+func (i *Issuer) SignReport(req *http.Request, room string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	msg := messagePrefix + timestamp + "." + room + "." + string(body)
+	req.Header.Set(ReportTimestampHeader, timestamp)
+	req.Header.Set(ReportSignatureHeader, i.keyID+"."+base64.RawURLEncoding.EncodeToString(mac(i.secret, msg)))
+}
+
+func mac(secret []byte, signed string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(signed))
+	return h.Sum(nil)
+}